@@ -1,27 +1,33 @@
 package main
 
 import (
+	"context"
 	"flag"
+	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/sirupsen/logrus"
 
+	"github.com/mjudeikis/osa-labs/pkg/config"
 	"github.com/mjudeikis/osa-labs/pkg/server"
+	"github.com/mjudeikis/osa-labs/pkg/store"
+	"github.com/mjudeikis/osa-labs/pkg/workers"
 )
 
 var (
-	devMode      = flag.Bool("dev-mode", false, "If set, dummy files will be produced on startup")
-	hostname     = flag.String("hostname", "", "Application hostname")
-	address      = flag.String("address", ":8080", "Bind address")
-	workerImage  = flag.String("worker-image", "quay.io/mangirdas/labs-worker", "Worker container image")
-	workerNumber = flag.Int("worker-number", 5, "Number of workers")
+	configFile    = flag.String("config", "", "YAML configuration file. Defaults are used if empty")
+	devMode       = flag.Bool("dev-mode", false, "If set, dummy files will be produced on startup")
+	hostname      = flag.String("hostname", "", "Application hostname")
+	address       = flag.String("address", "", "Bind address")
+	workerBackend = flag.String("worker-backend", "", "Worker backend: kube, local or static")
+	workerImage   = flag.String("worker-image", "", "Worker container image")
+	workerNumber  = flag.Int("worker-number", 0, "Number of workers")
+	adminToken    = flag.String("admin-token", "", "Token protecting the admin API. Admin API is disabled if empty")
+	leaseTTL      = flag.Duration("lease-ttl", 0, "How long a handed out credential or worker stays reserved. 0 means forever")
+	labsFile      = flag.String("labs", "", "YAML file defining the labs to serve, replacing the labs of the configuration file")
 )
 
-// TODO:
-// Add caching for repeatable request from the same host
-// catch empty cred bash exception for python
-// Make hostname configurable across the board
-// manage credentials file in PVC!!!
-
 func main() {
 	flag.Parse()
 	logrus.SetLevel(logrus.DebugLevel)
@@ -29,18 +35,111 @@ func main() {
 	logrus.SetReportCaller(true)
 	log := logrus.NewEntry(logrus.StandardLogger())
 
-	if *hostname == "" {
-		*hostname = "http://localhost:8080"
+	cfg, err := loadConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	switch flag.Arg(0) {
+	case "import":
+		err := runImport(log, cfg, flag.Args()[1:])
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	case "export":
+		err := runExport(log, cfg, flag.Args()[1:])
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	case "teardown":
+		err := runTeardown(log, cfg, flag.Args()[1:])
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	case "gc":
+		err := runGC(log, cfg, flag.Args()[1:])
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
 	}
 
 	log.Info("starting the osa lab dispatcher")
-	s, err := server.New(log, *devMode, *hostname, *address, *workerImage, *workerNumber)
+	s, err := server.New(log, cfg)
 	if err != nil {
 		panic(err)
 	}
 
-	err = s.Run()
+	// SIGTERM drains in-flight requests before exiting
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer cancel()
+
+	err = s.Run(ctx)
 	if err != nil {
 		panic(err)
 	}
 }
+
+// loadConfig loads the configuration file, environment and flags, in
+// increasing order of precedence, and validates the result
+func loadConfig() (*config.Config, error) {
+	cfg, err := config.Load(*configFile)
+	if err != nil {
+		return nil, err
+	}
+
+	if *labsFile != "" {
+		err = cfg.LoadLabs(*labsFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "dev-mode":
+			cfg.DevMode = *devMode
+		case "hostname":
+			cfg.Hostname = *hostname
+		case "address":
+			cfg.Address = *address
+		case "worker-backend":
+			cfg.Workers.Backend = *workerBackend
+		case "worker-image":
+			cfg.Workers.Image = *workerImage
+		case "worker-number":
+			cfg.Workers.Number = *workerNumber
+		case "admin-token":
+			cfg.AdminToken = *adminToken
+		case "lease-ttl":
+			cfg.LeaseTTL = config.Duration{Duration: *leaseTTL}
+		}
+	})
+
+	return cfg, cfg.Validate()
+}
+
+// labStore opens the store of the named lab
+func labStore(log *logrus.Entry, cfg *config.Config, name string) (store.Store, error) {
+	l, err := cfg.Lab(name)
+	if err != nil {
+		return nil, err
+	}
+	return store.New(log, cfg.StorageDir, l.StoreNamespace)
+}
+
+// labWorkers returns the worker manager of the named lab
+func labWorkers(log *logrus.Entry, cfg *config.Config, name string) (workers.Workers, error) {
+	l, err := cfg.Lab(name)
+	if err != nil {
+		return nil, err
+	}
+	storage, err := store.New(log, cfg.StorageDir, l.StoreNamespace)
+	if err != nil {
+		return nil, err
+	}
+	return workers.New(log, storage, l.WorkerNamespace, cfg.LabWorkers(l))
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/mjudeikis/osa-labs/pkg/api"
+	"github.com/mjudeikis/osa-labs/pkg/config"
+	"github.com/mjudeikis/osa-labs/pkg/inventory"
+)
+
+// runExport writes a report of the inventory and assignment history
+func runExport(log *logrus.Entry, cfg *config.Config, args []string) error {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	format := flags.String("format", "json", "Output format: json or csv")
+	table := flags.String("table", string(inventory.TableAssignments), "Table to export as csv: assignments, credentials or workers")
+	output := flags.String("o", "", "Output file. Defaults to stdout")
+	lab := flags.String("lab", config.DefaultLab, "Lab to export")
+	flags.Parse(args)
+
+	storage, err := labStore(log, cfg, *lab)
+	if err != nil {
+		return err
+	}
+
+	// the worker backend knows the reservations, the store only a snapshot
+	wm, err := labWorkers(log, cfg, *lab)
+	if err != nil {
+		return err
+	}
+	workers, err := wm.List()
+	if err != nil {
+		return err
+	}
+
+	report, err := inventory.LoadReport(storage, &api.WorkersStore{Workers: workers}, time.Now())
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	switch *format {
+	case "json":
+		return report.WriteJSON(w)
+	case "csv":
+		return report.WriteCSV(w, inventory.Table(*table))
+	}
+	return fmt.Errorf("unsupported format %q", *format)
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/mjudeikis/osa-labs/pkg/config"
+	"github.com/mjudeikis/osa-labs/pkg/inventory"
+	"github.com/mjudeikis/osa-labs/pkg/server"
+)
+
+// importTimeout bounds the import request to the server
+const importTimeout = time.Minute
+
+// runImport imports credentials from a file through the admin API of the
+// running server. The server serialises the import with the reservations it
+// hands out, which writing to its storage directory would not.
+func runImport(log *logrus.Entry, cfg *config.Config, args []string) error {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	format := flags.String("format", "", "Input format: csv, yaml or json. Guessed from the file extension if empty")
+	mode := flags.String("mode", string(inventory.ModeMerge), "Import mode: add, merge or replace")
+	lab := flags.String("lab", config.DefaultLab, "Lab to import credentials into")
+	serverURL := flags.String("server", cfg.Hostname, "URL of the running frontend")
+	insecure := flags.Bool("insecure", false, "Do not verify the TLS certificate of the frontend")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		return fmt.Errorf("usage: frontend import [-lab NAME] [-server URL] [-format csv|yaml|json] [-mode add|merge|replace] FILE")
+	}
+	file := flags.Arg(0)
+
+	if cfg.AdminToken == "" {
+		return fmt.Errorf("the admin token of the frontend must be set to import credentials")
+	}
+	_, err := cfg.Lab(*lab)
+	if err != nil {
+		return err
+	}
+	importFormat := inventory.Format(*format)
+	if importFormat == "" {
+		importFormat, err = inventory.FormatFromFilename(file)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = inventory.ParseMode(*mode)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	query := url.Values{}
+	query.Set("format", string(importFormat))
+	query.Set("mode", *mode)
+	endpoint := strings.TrimSuffix(*serverURL, "/") + "/labs/" + url.PathEscape(*lab) + "/admin/credentials/import?" + query.Encode()
+
+	req, err := http.NewRequest(http.MethodPost, endpoint, f)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+cfg.AdminToken)
+
+	client := &http.Client{Timeout: importTimeout}
+	if *insecure {
+		client.Transport = &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var apiErr struct {
+			Error *server.Error `json:"error"`
+		}
+		if json.NewDecoder(resp.Body).Decode(&apiErr) != nil || apiErr.Error == nil {
+			return fmt.Errorf("importing credentials: %s", resp.Status)
+		}
+		return fmt.Errorf("importing credentials: %s", apiErr.Error.Message)
+	}
+
+	var result inventory.ImportResult
+	err = json.NewDecoder(resp.Body).Decode(&result)
+	if err != nil {
+		return err
+	}
+
+	log.Infof("imported credentials: %d added, %d updated, %d removed", result.Added, result.Updated, result.Removed)
+	return nil
+}
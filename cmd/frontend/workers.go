@@ -0,0 +1,56 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/mjudeikis/osa-labs/pkg/config"
+)
+
+// runTeardown removes the named workers, or all workers, of a lab
+func runTeardown(log *logrus.Entry, cfg *config.Config, args []string) error {
+	flags := flag.NewFlagSet("teardown", flag.ExitOnError)
+	lab := flags.String("lab", config.DefaultLab, "Lab to remove workers from")
+	all := flags.Bool("all", false, "Remove all workers, including reserved ones")
+	flags.Parse(args)
+
+	if *all == (flags.NArg() > 0) {
+		return fmt.Errorf("usage: frontend teardown [-lab NAME] -all | WORKER...")
+	}
+
+	wm, err := labWorkers(log, cfg, *lab)
+	if err != nil {
+		return err
+	}
+
+	if *all {
+		return wm.Teardown()
+	}
+	for _, name := range flags.Args() {
+		found, err := wm.Delete(name)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return fmt.Errorf("worker %q not found", name)
+		}
+		log.Infof("removed worker %s", name)
+	}
+	return nil
+}
+
+// runGC repairs incomplete workers of a lab and removes what removed workers
+// left behind
+func runGC(log *logrus.Entry, cfg *config.Config, args []string) error {
+	flags := flag.NewFlagSet("gc", flag.ExitOnError)
+	lab := flags.String("lab", config.DefaultLab, "Lab to collect garbage in")
+	flags.Parse(args)
+
+	wm, err := labWorkers(log, cfg, *lab)
+	if err != nil {
+		return err
+	}
+	return wm.GC()
+}
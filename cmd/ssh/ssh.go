@@ -2,6 +2,7 @@ package main
 
 import (
 	"flag"
+	"net"
 
 	"github.com/sirupsen/logrus"
 
@@ -9,8 +10,10 @@ import (
 )
 
 var (
-	port      = flag.String("port", "2222", "Bind address")
+	address   = flag.String("address", "0.0.0.0", "Bind address")
+	port      = flag.String("port", "2222", "Bind port")
 	publicKey = flag.String("public-key", "/data/id_rsa.pub", "Public key location")
+	hostKey   = flag.String("host-key", "/data/id_rsa", "Host key location")
 )
 
 func main() {
@@ -21,7 +24,7 @@ func main() {
 	log := logrus.NewEntry(logrus.StandardLogger())
 
 	log.Info("starting the lab ssh server")
-	s, err := ssh.New(log, *port, *publicKey)
+	s, err := ssh.New(log, net.JoinHostPort(*address, *port), *publicKey, *hostKey)
 	if err != nil {
 		panic(err)
 	}
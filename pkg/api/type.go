@@ -1,9 +1,44 @@
 package api
 
+import "time"
+
+// Lab is one lab (event) served by the dispatcher, with its own credential
+// and worker pools
+type Lab struct {
+	Name            string `json:"name"`
+	Title           string `json:"title,omitempty"`
+	Instructions    string `json:"instructions,omitempty"`
+	Logo            string `json:"logo,omitempty"`
+	Welcome         string `json:"welcome,omitempty"`
+	SetupTemplate   string `json:"setupTemplate,omitempty"`
+	WorkerImage     string `json:"workerImage,omitempty"`
+	WorkerNumber    int    `json:"workerNumber,omitempty"`
+	WorkerNamespace string `json:"workerNamespace,omitempty"`
+	StoreNamespace  string `json:"storeNamespace,omitempty"`
+}
+
+type LabsConfig struct {
+	Labs []Lab `json:"labs"`
+}
+
+// Lease records who holds a reserved resource and until when
+type Lease struct {
+	Claim      string    `json:"claim,omitempty"`
+	Holder     string    `json:"holder"`
+	ReservedAt time.Time `json:"reservedAt"`
+	ExpiresAt  time.Time `json:"expiresAt,omitempty"`
+}
+
+// Expired returns true if the lease has an expiry time and it has passed
+func (l *Lease) Expired(now time.Time) bool {
+	return l != nil && !l.ExpiresAt.IsZero() && now.After(l.ExpiresAt)
+}
+
 type Credential struct {
 	Username string `json:"username"`
 	Password string `json:"password"`
 	Reserved bool   `json:"reserved"`
+	Lease    *Lease `json:"lease,omitempty"`
 	Metadata string `json:"metadata,omitempty"`
 }
 
@@ -12,12 +47,54 @@ type CredentialsStore struct {
 }
 
 type Worker struct {
-	IP       string `json:"ip"`
+	// Host and Port are where participants reach the worker over SSH
+	Host string `json:"host"`
+	Port int32  `json:"port"`
+	// User is the user participants log in as. The SSH gateway finds the
+	// worker by it.
+	User string `json:"user,omitempty"`
+	// IP is the same as Host, kept for clients of older versions
+	IP string `json:"ip"`
+	// Address is the host:port the frontend reaches the worker at from
+	// inside the cluster, e.g. for the web terminal
+	Address  string `json:"-"`
 	SSHKey   string `json:"sshKey"`
 	Reserved bool   `json:"reserved"`
+	Lease    *Lease `json:"lease,omitempty"`
 	Name     string `json:"name"`
+	Metadata string `json:"metadata,omitempty"`
 }
 
 type WorkersStore struct {
 	Workers []Worker
 }
+
+// Session is a credential and worker handed out together to one participant
+type Session struct {
+	Claim      string      `json:"claim"`
+	Credential *Credential `json:"credential"`
+	Worker     *Worker     `json:"worker"`
+}
+
+// Assignment links the credential and worker handed out in a session
+type Assignment struct {
+	Claim      string     `json:"claim"`
+	Holder     string     `json:"holder"`
+	Username   string     `json:"username"`
+	Worker     string     `json:"worker"`
+	AssignedAt time.Time  `json:"assignedAt"`
+	ReleasedAt *time.Time `json:"releasedAt,omitempty"`
+	Reason     string     `json:"reason,omitempty"`
+}
+
+type AssignmentsStore struct {
+	Assignments []Assignment
+}
+
+// Waiting is returned to a participant queued for a free credential or worker
+type Waiting struct {
+	Claim    string `json:"claim"`
+	Position int    `json:"position"`
+	Reason   string `json:"reason"`
+	Poll     string `json:"poll"`
+}
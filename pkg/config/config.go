@@ -0,0 +1,537 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ghodss/yaml"
+
+	"github.com/mjudeikis/osa-labs/pkg/api"
+)
+
+// APIVersion is the configuration file version understood by this build
+const APIVersion = "osa-labs/v1"
+
+// DefaultLab is the name of the lab used when no labs are configured
+const DefaultLab = "default"
+
+const (
+	// defaultStoreNamespace is the store namespace of the default lab
+	defaultStoreNamespace = "credentials"
+	// GatewayStoreNamespace keeps the generated SSH gateway host key
+	GatewayStoreNamespace = "gateway"
+)
+
+const (
+	defaultTitle        = "ARHO Labs"
+	defaultInstructions = "https://gitlab.com/redhatsummitlabs/experience-managed-openshift-on-azure/raw/master/lab_instructions.asciidoc"
+	defaultWelcome      = "This page explains how to set up the lab environment. All you need is to execute the command below from your lab workstation and record the credentials."
+)
+
+// dnsLabelRegexp matches RFC 1123 labels, which lab names, store namespaces
+// and worker namespaces must be
+var dnsLabelRegexp = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
+
+func isDNSLabel(value string) bool {
+	return len(value) <= 63 && dnsLabelRegexp.MatchString(value)
+}
+
+// Config is the frontend configuration
+type Config struct {
+	APIVersion  string   `json:"apiVersion"`
+	DevMode     bool     `json:"devMode,omitempty"`
+	Hostname    string   `json:"hostname,omitempty"`
+	Address     string   `json:"address,omitempty"`
+	AdminToken  string   `json:"adminToken,omitempty"`
+	LeaseTTL    Duration `json:"leaseTTL,omitempty"`
+	StorageDir  string   `json:"storageDir,omitempty"`
+	TemplateDir string   `json:"templateDir,omitempty"`
+	// TrustedProxies are the addresses or CIDRs of reverse proxies whose
+	// X-Forwarded-For header identifies participants
+	TrustedProxies []string  `json:"trustedProxies,omitempty"`
+	Workers        Workers   `json:"workers,omitempty"`
+	Gateway        Gateway   `json:"gateway,omitempty"`
+	Labs           []api.Lab `json:"labs,omitempty"`
+}
+
+// Workers configures the worker deployments. Labs may override the image and
+// number.
+type Workers struct {
+	// Backend runs the workers, one of the Backend constants
+	Backend         string `json:"backend,omitempty"`
+	Image           string `json:"image,omitempty"`
+	Number          int    `json:"number,omitempty"`
+	Port            int32  `json:"port,omitempty"`
+	ImagePullPolicy string `json:"imagePullPolicy,omitempty"`
+	// Spares is the number of free workers kept on top of the reserved ones
+	Spares int `json:"spares,omitempty"`
+	// Max caps the pool when scaling up for spares. It is at least Number.
+	Max int `json:"max,omitempty"`
+	// ScaleDownDelay is how long a free worker above the spares stays idle
+	// before it is removed
+	ScaleDownDelay Duration `json:"scaleDownDelay,omitempty"`
+	Exposure       Exposure `json:"exposure,omitempty"`
+	Local          Local    `json:"local,omitempty"`
+	// Static is the inventory of the static backend
+	Static []StaticWorker `json:"static,omitempty"`
+}
+
+// Worker backends
+const (
+	// BackendKube runs every worker as a deployment, service and secret
+	BackendKube = "kube"
+	// BackendLocal runs every worker as a local cmd/ssh process, e.g. in
+	// dev mode on a laptop
+	BackendLocal = "local"
+	// BackendStatic hands out pre-existing hosts listed in Static
+	BackendStatic = "static"
+)
+
+// Local configures the local backend
+type Local struct {
+	// Binary is the path of the cmd/ssh binary
+	Binary string `json:"binary,omitempty"`
+	// Host is where the local workers listen and participants reach them
+	Host string `json:"host,omitempty"`
+}
+
+// StaticWorker is a pre-existing host handed out by the static backend
+type StaticWorker struct {
+	Name string `json:"name"`
+	Host string `json:"host"`
+	Port int32  `json:"port,omitempty"`
+	User string `json:"user,omitempty"`
+	// SSHKeyFile is the private key participants and the frontend log in
+	// with
+	SSHKeyFile string `json:"sshKeyFile"`
+	// Address is the host:port the frontend reaches the host at, if it
+	// differs from Host and Port
+	Address string `json:"address,omitempty"`
+}
+
+// Exposure types
+const (
+	// ExposureLoadBalancer gives every worker a load balancer, reached at its
+	// ingress IP or hostname
+	ExposureLoadBalancer = "LoadBalancer"
+	// ExposureNodePort reaches every worker at its node port on NodeAddress
+	ExposureNodePort = "NodePort"
+	// ExposureGateway keeps workers on cluster IPs behind a shared SSH
+	// gateway at GatewayHost and GatewayPort
+	ExposureGateway = "Gateway"
+)
+
+// Exposure configures how participants reach the workers
+type Exposure struct {
+	Type        string `json:"type,omitempty"`
+	NodeAddress string `json:"nodeAddress,omitempty"`
+	GatewayHost string `json:"gatewayHost,omitempty"`
+	GatewayPort int32  `json:"gatewayPort,omitempty"`
+}
+
+// Gateway configures the SSH gateway the frontend runs with Gateway exposure
+type Gateway struct {
+	Address string `json:"address,omitempty"`
+	// HostKey is the path of the gateway host key. Without it a key is
+	// generated and kept in the storage directory.
+	HostKey string `json:"hostKey,omitempty"`
+}
+
+// Duration is a time.Duration written as a string, e.g. "8h"
+type Duration struct {
+	time.Duration
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	duration, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	d.Duration = duration
+	return nil
+}
+
+// Default returns the configuration used when no file is given
+func Default() *Config {
+	return &Config{
+		APIVersion: APIVersion,
+		Hostname:   "http://localhost:8080",
+		Address:    ":8080",
+		LeaseTTL:   Duration{8 * time.Hour},
+		StorageDir: "storage",
+		Workers: Workers{
+			Backend:         BackendKube,
+			Image:           "quay.io/mangirdas/labs-worker",
+			Number:          5,
+			Port:            2222,
+			ImagePullPolicy: "Always",
+			ScaleDownDelay:  Duration{30 * time.Minute},
+			Exposure: Exposure{
+				Type:        ExposureLoadBalancer,
+				GatewayPort: 22,
+			},
+			Local: Local{
+				Binary: "./ssh",
+				Host:   "localhost",
+			},
+		},
+		Gateway: Gateway{
+			Address: ":2200",
+		},
+	}
+}
+
+// Load reads the configuration file at path on top of the defaults and
+// applies environment overrides. An empty path loads the defaults only.
+func Load(path string) (*Config, error) {
+	c := Default()
+	if path != "" {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		err = yaml.Unmarshal(data, c)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		if c.APIVersion != APIVersion {
+			return nil, fmt.Errorf("%s: unsupported apiVersion %q, expected %q", path, c.APIVersion, APIVersion)
+		}
+	}
+
+	err := c.applyEnv()
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// LoadLabs reads a file with a top level labs list, replacing the configured
+// labs
+func (c *Config) LoadLabs(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var labs api.LabsConfig
+	err = yaml.Unmarshal(data, &labs)
+	if err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	if len(labs.Labs) == 0 {
+		return fmt.Errorf("no labs configured in %s", path)
+	}
+	c.Labs = labs.Labs
+	return nil
+}
+
+type envVar struct {
+	name  string
+	field interface{}
+}
+
+// env lists the environment variables overriding configuration fields, in
+// the order they are applied
+func (c *Config) env() []envVar {
+	return []envVar{
+		{"OSA_LABS_DEV_MODE", &c.DevMode},
+		{"OSA_LABS_HOSTNAME", &c.Hostname},
+		{"OSA_LABS_ADDRESS", &c.Address},
+		{"ADMIN_TOKEN", &c.AdminToken},
+		{"OSA_LABS_ADMIN_TOKEN", &c.AdminToken},
+		{"OSA_LABS_LEASE_TTL", &c.LeaseTTL.Duration},
+		{"OSA_LABS_STORAGE_DIR", &c.StorageDir},
+		{"OSA_LABS_TEMPLATE_DIR", &c.TemplateDir},
+		{"OSA_LABS_TRUSTED_PROXIES", &c.TrustedProxies},
+		{"OSA_LABS_WORKER_BACKEND", &c.Workers.Backend},
+		{"OSA_LABS_WORKER_IMAGE", &c.Workers.Image},
+		{"OSA_LABS_WORKER_NUMBER", &c.Workers.Number},
+		{"OSA_LABS_WORKER_PORT", &c.Workers.Port},
+		{"OSA_LABS_WORKER_IMAGE_PULL_POLICY", &c.Workers.ImagePullPolicy},
+		{"OSA_LABS_WORKER_SPARES", &c.Workers.Spares},
+		{"OSA_LABS_WORKER_MAX", &c.Workers.Max},
+		{"OSA_LABS_WORKER_SCALE_DOWN_DELAY", &c.Workers.ScaleDownDelay.Duration},
+		{"OSA_LABS_WORKER_EXPOSURE", &c.Workers.Exposure.Type},
+		{"OSA_LABS_WORKER_NODE_ADDRESS", &c.Workers.Exposure.NodeAddress},
+		{"OSA_LABS_WORKER_GATEWAY_HOST", &c.Workers.Exposure.GatewayHost},
+		{"OSA_LABS_WORKER_GATEWAY_PORT", &c.Workers.Exposure.GatewayPort},
+		{"OSA_LABS_WORKER_LOCAL_BINARY", &c.Workers.Local.Binary},
+		{"OSA_LABS_WORKER_LOCAL_HOST", &c.Workers.Local.Host},
+		{"OSA_LABS_GATEWAY_ADDRESS", &c.Gateway.Address},
+		{"OSA_LABS_GATEWAY_HOST_KEY", &c.Gateway.HostKey},
+	}
+}
+
+func (c *Config) applyEnv() error {
+	for _, env := range c.env() {
+		value := os.Getenv(env.name)
+		if value == "" {
+			continue
+		}
+		var err error
+		switch field := env.field.(type) {
+		case *string:
+			*field = value
+		case *bool:
+			*field, err = strconv.ParseBool(value)
+		case *int:
+			*field, err = strconv.Atoi(value)
+		case *int32:
+			var i int64
+			i, err = strconv.ParseInt(value, 10, 32)
+			*field = int32(i)
+		case *time.Duration:
+			*field, err = time.ParseDuration(value)
+		case *[]string:
+			*field = strings.Split(value, ",")
+		}
+		if err != nil {
+			return fmt.Errorf("invalid %s: %v", env.name, err)
+		}
+	}
+	return nil
+}
+
+// Validate checks the configuration and fills in lab defaults. It must be
+// called before the configuration is used.
+func (c *Config) Validate() error {
+	if c.Address == "" {
+		return fmt.Errorf("address must be set")
+	}
+	if c.Hostname == "" {
+		return fmt.Errorf("hostname must be set")
+	}
+	if c.LeaseTTL.Duration < 0 {
+		return fmt.Errorf("leaseTTL must not be negative")
+	}
+	if c.StorageDir == "" {
+		return fmt.Errorf("storageDir must be set")
+	}
+	if _, err := ParseNetworks(c.TrustedProxies); err != nil {
+		return fmt.Errorf("invalid trustedProxies: %v", err)
+	}
+	if c.Workers.Number < 0 {
+		return fmt.Errorf("workers.number must not be negative")
+	}
+	if c.Workers.Spares < 0 {
+		return fmt.Errorf("workers.spares must not be negative")
+	}
+	if c.Workers.Max < 0 {
+		return fmt.Errorf("workers.max must not be negative")
+	}
+	if c.Workers.ScaleDownDelay.Duration < 0 {
+		return fmt.Errorf("workers.scaleDownDelay must not be negative")
+	}
+	if c.Workers.Port <= 0 || c.Workers.Port > 65535 {
+		return fmt.Errorf("invalid workers.port %d", c.Workers.Port)
+	}
+	switch c.Workers.ImagePullPolicy {
+	case "Always", "IfNotPresent", "Never":
+	default:
+		return fmt.Errorf("invalid workers.imagePullPolicy %q", c.Workers.ImagePullPolicy)
+	}
+
+	switch c.Workers.Exposure.Type {
+	case ExposureLoadBalancer:
+	case ExposureNodePort:
+		if c.Workers.Exposure.NodeAddress == "" {
+			return fmt.Errorf("workers.exposure.nodeAddress must be set for NodePort exposure")
+		}
+	case ExposureGateway:
+		if c.Workers.Exposure.GatewayHost == "" {
+			return fmt.Errorf("workers.exposure.gatewayHost must be set for Gateway exposure")
+		}
+		if c.Workers.Exposure.GatewayPort <= 0 || c.Workers.Exposure.GatewayPort > 65535 {
+			return fmt.Errorf("invalid workers.exposure.gatewayPort %d", c.Workers.Exposure.GatewayPort)
+		}
+		if c.Gateway.Address == "" {
+			return fmt.Errorf("gateway.address must be set for Gateway exposure")
+		}
+	default:
+		return fmt.Errorf("invalid workers.exposure.type %q", c.Workers.Exposure.Type)
+	}
+
+	if len(c.Labs) == 0 {
+		c.Labs = []api.Lab{{Name: DefaultLab}}
+	}
+
+	switch c.Workers.Backend {
+	case BackendKube:
+	case BackendLocal:
+		if c.Workers.Local.Binary == "" {
+			return fmt.Errorf("workers.local.binary must be set for the local backend")
+		}
+		if c.Workers.Local.Host == "" {
+			return fmt.Errorf("workers.local.host must be set for the local backend")
+		}
+	case BackendStatic:
+		err := c.validateStatic()
+		if err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("invalid workers.backend %q", c.Workers.Backend)
+	}
+	seen := map[string]bool{}
+	storeNamespaces := map[string]string{}
+	workerNamespaces := map[string]string{}
+	for i := range c.Labs {
+		l := &c.Labs[i]
+		if !isDNSLabel(l.Name) {
+			return fmt.Errorf("invalid lab name %q", l.Name)
+		}
+		// lab stores are named after the lab by default
+		if l.Name == defaultStoreNamespace || l.Name == GatewayStoreNamespace {
+			return fmt.Errorf("lab name %q is reserved", l.Name)
+		}
+		if seen[l.Name] {
+			return fmt.Errorf("duplicate lab name %q", l.Name)
+		}
+		seen[l.Name] = true
+		c.setLabDefaults(l)
+		if !isDNSLabel(l.StoreNamespace) {
+			return fmt.Errorf("lab %s: invalid storeNamespace %q", l.Name, l.StoreNamespace)
+		}
+		if l.StoreNamespace == GatewayStoreNamespace {
+			return fmt.Errorf("lab %s: storeNamespace %q is reserved", l.Name, l.StoreNamespace)
+		}
+		if other, ok := storeNamespaces[l.StoreNamespace]; ok {
+			return fmt.Errorf("labs %s and %s share storeNamespace %q", other, l.Name, l.StoreNamespace)
+		}
+		storeNamespaces[l.StoreNamespace] = l.Name
+		if !isDNSLabel(l.WorkerNamespace) {
+			return fmt.Errorf("lab %s: invalid workerNamespace %q", l.Name, l.WorkerNamespace)
+		}
+		// the workers of both labs would be reconciled and collected by each
+		if other, ok := workerNamespaces[l.WorkerNamespace]; ok {
+			return fmt.Errorf("labs %s and %s share workerNamespace %q", other, l.Name, l.WorkerNamespace)
+		}
+		workerNamespaces[l.WorkerNamespace] = l.Name
+		if l.WorkerNumber < 0 {
+			return fmt.Errorf("lab %s: workerNumber must not be negative", l.Name)
+		}
+	}
+	return nil
+}
+
+// ParseNetworks parses addresses and CIDRs, an address being a network of
+// its own
+func ParseNetworks(values []string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if ip := net.ParseIP(value); ip != nil {
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(value)
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// Lab returns the named lab
+func (c *Config) Lab(name string) (*api.Lab, error) {
+	for i := range c.Labs {
+		if c.Labs[i].Name == name {
+			return &c.Labs[i], nil
+		}
+	}
+	return nil, fmt.Errorf("lab %q not found", name)
+}
+
+// LabWorkers returns the worker configuration of a lab
+func (c *Config) LabWorkers(l *api.Lab) Workers {
+	workers := c.Workers
+	workers.Image = l.WorkerImage
+	workers.Number = l.WorkerNumber
+	if workers.Max < workers.Number {
+		workers.Max = workers.Number
+	}
+	return workers
+}
+
+func (c *Config) setLabDefaults(l *api.Lab) {
+	if l.Title == "" {
+		l.Title = defaultTitle
+	}
+	if l.Instructions == "" {
+		l.Instructions = defaultInstructions
+	}
+	if l.Welcome == "" {
+		l.Welcome = defaultWelcome
+	}
+	if l.SetupTemplate == "" {
+		l.SetupTemplate = "setup.sh"
+	}
+	if l.WorkerImage == "" {
+		l.WorkerImage = c.Workers.Image
+	}
+	if l.WorkerNumber == 0 {
+		l.WorkerNumber = c.Workers.Number
+	}
+	// the default lab keeps the locations used before labs existed
+	if l.WorkerNamespace == "" {
+		l.WorkerNamespace = "workers-" + l.Name
+		if l.Name == DefaultLab {
+			l.WorkerNamespace = "workers"
+		}
+	}
+	if l.StoreNamespace == "" {
+		l.StoreNamespace = l.Name
+		if l.Name == DefaultLab {
+			l.StoreNamespace = defaultStoreNamespace
+		}
+	}
+}
+
+// validateStatic checks the static inventory and fills in its defaults
+func (c *Config) validateStatic() error {
+	if len(c.Workers.Static) == 0 {
+		return fmt.Errorf("workers.static must list the hosts of the static backend")
+	}
+	// the hosts cannot be shared between the pools of several labs
+	if len(c.Labs) > 1 {
+		return fmt.Errorf("the static backend serves a single lab")
+	}
+	seen := map[string]bool{}
+	for i := range c.Workers.Static {
+		wk := &c.Workers.Static[i]
+		if wk.Name == "" || wk.Host == "" || wk.SSHKeyFile == "" {
+			return fmt.Errorf("workers.static[%d]: name, host and sshKeyFile must be set", i)
+		}
+		if seen[wk.Name] {
+			return fmt.Errorf("duplicate static worker %q", wk.Name)
+		}
+		seen[wk.Name] = true
+		if wk.Port == 0 {
+			wk.Port = 22
+		}
+		if wk.Port < 0 || wk.Port > 65535 {
+			return fmt.Errorf("static worker %s: invalid port %d", wk.Name, wk.Port)
+		}
+	}
+	return nil
+}
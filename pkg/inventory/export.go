@@ -0,0 +1,145 @@
+package inventory
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/mjudeikis/osa-labs/pkg/api"
+	"github.com/mjudeikis/osa-labs/pkg/store"
+)
+
+// Table selects what a CSV export contains
+type Table string
+
+const (
+	TableAssignments Table = "assignments"
+	TableCredentials Table = "credentials"
+	TableWorkers     Table = "workers"
+)
+
+// Summary counts the state of a pool
+type Summary struct {
+	Total    int `json:"total"`
+	Reserved int `json:"reserved"`
+	Free     int `json:"free"`
+	// Expired counts reserved entries with an expired lease which have not
+	// been returned to the pool yet
+	Expired int `json:"expired"`
+}
+
+// Report is a point in time export of the inventory and assignment history
+type Report struct {
+	GeneratedAt        time.Time        `json:"generatedAt"`
+	CredentialsSummary Summary          `json:"credentialsSummary"`
+	WorkersSummary     Summary          `json:"workersSummary"`
+	Credentials        []api.Credential `json:"credentials"`
+	Workers            []api.Worker     `json:"workers"`
+	Assignments        []api.Assignment `json:"assignments"`
+}
+
+// NewReport builds a report. SSH keys of workers are left out.
+func NewReport(credentialStore *api.CredentialsStore, workerStore *api.WorkersStore, assignmentStore *api.AssignmentsStore, now time.Time) *Report {
+	report := &Report{
+		GeneratedAt: now.UTC(),
+		Credentials: []api.Credential{},
+		Workers:     []api.Worker{},
+		Assignments: []api.Assignment{},
+	}
+
+	if credentialStore != nil {
+		for _, cred := range credentialStore.Credentials {
+			report.CredentialsSummary.add(cred.Reserved, cred.Lease, now)
+			report.Credentials = append(report.Credentials, cred)
+		}
+	}
+	if workerStore != nil {
+		for _, wk := range workerStore.Workers {
+			report.WorkersSummary.add(wk.Reserved, wk.Lease, now)
+			wk.SSHKey = ""
+			report.Workers = append(report.Workers, wk)
+		}
+	}
+	if assignmentStore != nil {
+		report.Assignments = append(report.Assignments, assignmentStore.Assignments...)
+	}
+	return report
+}
+
+func (s *Summary) add(reserved bool, lease *api.Lease, now time.Time) {
+	s.Total++
+	if !reserved {
+		s.Free++
+		return
+	}
+	s.Reserved++
+	if lease.Expired(now) {
+		s.Expired++
+	}
+}
+
+// WriteJSON writes the whole report as JSON
+func (r *Report) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// WriteCSV writes one table of the report as CSV
+func (r *Report) WriteCSV(w io.Writer, table Table) error {
+	cw := csv.NewWriter(w)
+	switch table {
+	case TableAssignments:
+		cw.Write([]string{"claim", "holder", "username", "worker", "assignedAt", "releasedAt", "reason"})
+		for _, a := range r.Assignments {
+			cw.Write([]string{a.Claim, a.Holder, a.Username, a.Worker, formatTime(&a.AssignedAt), formatTime(a.ReleasedAt), a.Reason})
+		}
+	case TableCredentials:
+		cw.Write([]string{"username", "reserved", "claim", "holder", "reservedAt", "expiresAt", "metadata"})
+		for _, cred := range r.Credentials {
+			cw.Write(append([]string{cred.Username, strconv.FormatBool(cred.Reserved)}, append(leaseColumns(cred.Lease), cred.Metadata)...))
+		}
+	case TableWorkers:
+		cw.Write([]string{"name", "host", "port", "reserved", "claim", "holder", "reservedAt", "expiresAt", "metadata"})
+		for _, wk := range r.Workers {
+			cw.Write(append([]string{wk.Name, wk.Host, strconv.Itoa(int(wk.Port)), strconv.FormatBool(wk.Reserved)}, append(leaseColumns(wk.Lease), wk.Metadata)...))
+		}
+	default:
+		return fmt.Errorf("unsupported table %q", table)
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func leaseColumns(lease *api.Lease) []string {
+	if lease == nil {
+		return []string{"", "", "", ""}
+	}
+	return []string{lease.Claim, lease.Holder, formatTime(&lease.ReservedAt), formatTime(&lease.ExpiresAt)}
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil || t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// LoadReport builds a report of the workers listed by the worker backend and
+// the credentials and assignments in the store. Missing pools are reported as
+// empty.
+func LoadReport(st store.Store, workerStore *api.WorkersStore, now time.Time) (*Report, error) {
+	credentialStore, err := LoadCredentials(st)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	assignmentStore, err := LoadAssignments(st)
+	if err != nil {
+		return nil, err
+	}
+	return NewReport(credentialStore, workerStore, assignmentStore, now), nil
+}
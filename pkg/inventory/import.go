@@ -0,0 +1,202 @@
+package inventory
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/ghodss/yaml"
+
+	"github.com/mjudeikis/osa-labs/pkg/api"
+)
+
+type Format string
+
+const (
+	FormatCSV  Format = "csv"
+	FormatYAML Format = "yaml"
+	FormatJSON Format = "json"
+)
+
+// FormatFromFilename guesses the import format from the file extension
+func FormatFromFilename(name string) (Format, error) {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".csv":
+		return FormatCSV, nil
+	case ".yaml", ".yml":
+		return FormatYAML, nil
+	case ".json":
+		return FormatJSON, nil
+	}
+	return "", fmt.Errorf("unable to guess format of %q", name)
+}
+
+type Mode string
+
+const (
+	// ModeAdd adds new credentials and fails if any of them already exist
+	ModeAdd Mode = "add"
+	// ModeMerge adds new credentials and updates the password and metadata of
+	// existing ones, keeping their reservations
+	ModeMerge Mode = "merge"
+	// ModeReplace replaces the whole pool. Reservations of credentials present
+	// in both the pool and the import are kept.
+	ModeReplace Mode = "replace"
+)
+
+// ParseMode validates an import mode. Empty mode defaults to merge.
+func ParseMode(mode string) (Mode, error) {
+	switch Mode(mode) {
+	case "":
+		return ModeMerge, nil
+	case ModeAdd, ModeMerge, ModeReplace:
+		return Mode(mode), nil
+	}
+	return "", fmt.Errorf("unsupported import mode %q", mode)
+}
+
+// ImportResult summarises the changes made by an import
+type ImportResult struct {
+	Added   int `json:"added"`
+	Updated int `json:"updated"`
+	Removed int `json:"removed"`
+}
+
+// ParseCredentials reads a list of credentials in the given format. CSV input
+// must have a header row with username and password columns and may have a
+// metadata column.
+func ParseCredentials(r io.Reader, format Format) ([]api.Credential, error) {
+	var creds []api.Credential
+	switch format {
+	case FormatCSV:
+		var err error
+		creds, err = parseCSV(r)
+		if err != nil {
+			return nil, err
+		}
+	case FormatYAML, FormatJSON:
+		data, err := ioutil.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		err = yaml.Unmarshal(data, &creds)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported format %q", format)
+	}
+
+	seen := map[string]bool{}
+	for i, cred := range creds {
+		if cred.Username == "" || cred.Password == "" {
+			return nil, fmt.Errorf("entry %d: username and password are required", i+1)
+		}
+		if seen[cred.Username] {
+			return nil, fmt.Errorf("entry %d: duplicate username %q", i+1, cred.Username)
+		}
+		seen[cred.Username] = true
+		// reservations can't be imported
+		creds[i].Reserved = false
+		creds[i].Lease = nil
+	}
+	return creds, nil
+}
+
+func parseCSV(r io.Reader) ([]api.Credential, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+
+	columns := map[string]int{}
+	for i, name := range records[0] {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, required := range []string{"username", "password"} {
+		if _, ok := columns[required]; !ok {
+			return nil, fmt.Errorf("csv header is missing %q column", required)
+		}
+	}
+
+	var creds []api.Credential
+	for _, record := range records[1:] {
+		cred := api.Credential{
+			Username: record[columns["username"]],
+			Password: record[columns["password"]],
+		}
+		if i, ok := columns["metadata"]; ok {
+			cred.Metadata = record[i]
+		}
+		creds = append(creds, cred)
+	}
+	return creds, nil
+}
+
+// ImportCredentials merges creds into the credential store according to mode
+func ImportCredentials(credentialStore *api.CredentialsStore, creds []api.Credential, mode Mode) (*ImportResult, error) {
+	result := &ImportResult{}
+
+	existing := map[string]int{}
+	for key, cred := range credentialStore.Credentials {
+		existing[cred.Username] = key
+	}
+
+	switch mode {
+	case ModeAdd:
+		for _, cred := range creds {
+			if _, ok := existing[cred.Username]; ok {
+				return nil, fmt.Errorf("credential %q already exists", cred.Username)
+			}
+		}
+		credentialStore.Credentials = append(credentialStore.Credentials, creds...)
+		result.Added = len(creds)
+
+	case ModeMerge:
+		for _, cred := range creds {
+			key, ok := existing[cred.Username]
+			if !ok {
+				credentialStore.Credentials = append(credentialStore.Credentials, cred)
+				result.Added++
+				continue
+			}
+			credentialStore.Credentials[key].Password = cred.Password
+			credentialStore.Credentials[key].Metadata = cred.Metadata
+			result.Updated++
+		}
+
+	case ModeReplace:
+		imported := map[string]bool{}
+		replaced := make([]api.Credential, 0, len(creds))
+		for _, cred := range creds {
+			imported[cred.Username] = true
+			if key, ok := existing[cred.Username]; ok {
+				cred.Reserved = credentialStore.Credentials[key].Reserved
+				cred.Lease = credentialStore.Credentials[key].Lease
+				result.Updated++
+			} else {
+				result.Added++
+			}
+			replaced = append(replaced, cred)
+		}
+		for _, cred := range credentialStore.Credentials {
+			if !imported[cred.Username] {
+				result.Removed++
+			}
+		}
+		credentialStore.Credentials = replaced
+
+	default:
+		return nil, fmt.Errorf("unsupported import mode %q", mode)
+	}
+
+	return result, nil
+}
@@ -0,0 +1,83 @@
+package inventory
+
+import (
+	"os"
+
+	"github.com/ghodss/yaml"
+
+	"github.com/mjudeikis/osa-labs/pkg/api"
+	"github.com/mjudeikis/osa-labs/pkg/store"
+)
+
+// LoadCredentials reads the credential pool from the store
+func LoadCredentials(st store.Store) (*api.CredentialsStore, error) {
+	data, err := st.Get("credentials")
+	if err != nil {
+		return nil, err
+	}
+	var credentialStore api.CredentialsStore
+	err = yaml.Unmarshal(data, &credentialStore)
+	if err != nil {
+		return nil, err
+	}
+	return &credentialStore, nil
+}
+
+// SaveCredentials writes the credential pool to the store
+func SaveCredentials(st store.Store, credentialStore *api.CredentialsStore) error {
+	data, err := yaml.Marshal(credentialStore)
+	if err != nil {
+		return err
+	}
+	return st.Put("credentials", data)
+}
+
+// LoadWorkers reads the worker pool from the store
+func LoadWorkers(st store.Store) (*api.WorkersStore, error) {
+	data, err := st.Get("workers")
+	if err != nil {
+		return nil, err
+	}
+	var workerStore api.WorkersStore
+	err = yaml.Unmarshal(data, &workerStore)
+	if err != nil {
+		return nil, err
+	}
+	return &workerStore, nil
+}
+
+// SaveWorkers writes the worker pool to the store
+func SaveWorkers(st store.Store, workerStore *api.WorkersStore) error {
+	data, err := yaml.Marshal(workerStore)
+	if err != nil {
+		return err
+	}
+	return st.Put("workers", data)
+}
+
+// LoadAssignments reads the assignment history from the store. Missing
+// history is returned as empty.
+func LoadAssignments(st store.Store) (*api.AssignmentsStore, error) {
+	var assignmentStore api.AssignmentsStore
+	data, err := st.Get("assignments")
+	if os.IsNotExist(err) {
+		return &assignmentStore, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = yaml.Unmarshal(data, &assignmentStore)
+	if err != nil {
+		return nil, err
+	}
+	return &assignmentStore, nil
+}
+
+// SaveAssignments writes the assignment history to the store
+func SaveAssignments(st store.Store, assignmentStore *api.AssignmentsStore) error {
+	data, err := yaml.Marshal(assignmentStore)
+	if err != nil {
+		return err
+	}
+	return st.Put("assignments", data)
+}
@@ -0,0 +1,99 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const namespace = "osa_labs"
+
+var (
+	// Handouts counts credentials and workers newly handed out to participants
+	Handouts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "handouts_total",
+		Help:      "Credentials and workers handed out to participants.",
+	}, []string{"lab", "pool"})
+
+	// RequestDuration observes handler latency by endpoint
+	RequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Latency of HTTP requests by endpoint.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"lab", "handler", "method"})
+
+	// RequestErrors counts responses with an error status by endpoint
+	RequestErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_request_errors_total",
+		Help:      "HTTP responses with a 4xx or 5xx status by endpoint.",
+	}, []string{"lab", "handler", "code"})
+
+	// ReconcileDuration observes how long syncing a worker into the
+	// inventory took
+	ReconcileDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "worker_reconcile_duration_seconds",
+		Help:      "Duration of syncing a changed worker into the inventory.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"namespace"})
+
+	// WorkersReady is the number of ready worker deployments and services
+	// in the inventory
+	WorkersReady = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "workers_ready",
+		Help:      "Ready worker deployments and services with an address in the inventory.",
+	}, []string{"namespace", "resource"})
+
+	// WorkersDesired is the pool size the autoscaler aims for
+	WorkersDesired = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "workers_desired",
+		Help:      "Number of workers the autoscaler aims for, reserved workers plus spares.",
+	}, []string{"namespace"})
+
+	// WorkersRecycled counts workers reset to a pristine state
+	WorkersRecycled = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "workers_recycled_total",
+		Help:      "Workers reset to a pristine state with a new SSH key.",
+	}, []string{"namespace"})
+
+	// WorkerGC counts incomplete workers repaired and orphaned worker
+	// resources removed
+	WorkerGC = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "worker_gc_total",
+		Help:      "Incomplete workers repaired and orphaned worker services and secrets removed.",
+	}, []string{"namespace", "action"})
+
+	// StoreDuration observes store operation latency
+	StoreDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "store_operation_duration_seconds",
+		Help:      "Latency of store operations.",
+		Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+	}, []string{"namespace", "operation"})
+)
+
+// PoolDesc describes the pool gauges collected by the server at scrape time
+var PoolDesc = prometheus.NewDesc(
+	prometheus.BuildFQName(namespace, "", "pool_entries"),
+	"Credentials and workers in the pool by state. Expired entries are also counted as reserved.",
+	[]string{"lab", "pool", "state"}, nil,
+)
+
+func init() {
+	prometheus.MustRegister(
+		Handouts,
+		RequestDuration,
+		RequestErrors,
+		ReconcileDuration,
+		WorkersReady,
+		WorkersDesired,
+		WorkersRecycled,
+		WorkerGC,
+		StoreDuration,
+	)
+}
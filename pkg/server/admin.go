@@ -0,0 +1,461 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mjudeikis/osa-labs/pkg/api"
+	"github.com/mjudeikis/osa-labs/pkg/inventory"
+)
+
+const adminHolder = "admin"
+
+// admin wraps handlers of the admin API with token authentication. The token
+// can be sent either as a bearer token or as the basic auth password.
+func (s *Server) admin(h labHandler) labHandler {
+	return func(w http.ResponseWriter, r *http.Request, l *lab) {
+		if s.adminToken == "" {
+			s.writeError(w, notFound("admin API is disabled"))
+			return
+		}
+		if !s.authorized(r) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="osa-labs admin"`)
+			s.writeError(w, errUnauthorized)
+			return
+		}
+		h(w, r, l)
+	}
+}
+
+func (s *Server) authorized(r *http.Request) bool {
+	var token string
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		token = strings.TrimPrefix(auth, "Bearer ")
+	} else if _, password, ok := r.BasicAuth(); ok {
+		token = password
+	}
+	return token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(s.adminToken)) == 1
+}
+
+func (s *Server) adminCredentials(w http.ResponseWriter, r *http.Request, l *lab) {
+	l.log.Debug("adminCredentials")
+	switch r.Method {
+	case http.MethodGet:
+		s.listCredentials(w, r, l)
+	case http.MethodPost:
+		s.addCredentials(w, r, l)
+	case http.MethodDelete:
+		s.removeCredential(w, r, l)
+	default:
+		s.writeError(w, errMethodNotAllowed)
+	}
+}
+
+func (s *Server) listCredentials(w http.ResponseWriter, r *http.Request, l *lab) {
+	reserved, err := reservedFilter(r)
+	if err != nil {
+		s.writeError(w, badRequest("%s", err))
+		return
+	}
+
+	lock.Lock()
+	credentialStore, err := l.loadCredentials()
+	lock.Unlock()
+	if err != nil {
+		s.writeError(w, err)
+		return
+	}
+
+	result := []api.Credential{}
+	for _, cred := range credentialStore.Credentials {
+		if reserved == nil || cred.Reserved == *reserved {
+			result = append(result, cred)
+		}
+	}
+	s.writeJSON(w, http.StatusOK, result)
+}
+
+func (s *Server) addCredentials(w http.ResponseWriter, r *http.Request, l *lab) {
+	var creds []api.Credential
+	err := json.NewDecoder(r.Body).Decode(&creds)
+	if err != nil {
+		s.writeError(w, badRequest("%s", err))
+		return
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	credentialStore, err := l.loadCredentials()
+	if err != nil {
+		s.writeError(w, err)
+		return
+	}
+
+	existing := map[string]bool{}
+	for _, cred := range credentialStore.Credentials {
+		existing[cred.Username] = true
+	}
+	for _, cred := range creds {
+		if cred.Username == "" {
+			s.writeError(w, badRequest("username is required"))
+			return
+		}
+		if existing[cred.Username] {
+			s.writeError(w, conflict("credential %q already exists", cred.Username))
+			return
+		}
+		existing[cred.Username] = true
+	}
+
+	credentialStore.Credentials = append(credentialStore.Credentials, creds...)
+	err = l.saveCredentials(credentialStore)
+	if err != nil {
+		s.writeError(w, err)
+		return
+	}
+	l.waitlist.broadcast()
+	s.writeJSON(w, http.StatusCreated, creds)
+}
+
+func (s *Server) removeCredential(w http.ResponseWriter, r *http.Request, l *lab) {
+	username := r.FormValue("username")
+	if username == "" {
+		s.writeError(w, badRequest("username is required"))
+		return
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	credentialStore, err := l.loadCredentials()
+	if err != nil {
+		s.writeError(w, err)
+		return
+	}
+
+	for key, cred := range credentialStore.Credentials {
+		if cred.Username == username {
+			// the participant holding it would keep a lease on nothing
+			if cred.Reserved {
+				s.writeError(w, conflict("credential %q is reserved, unreserve it first", username))
+				return
+			}
+			credentialStore.Credentials = append(credentialStore.Credentials[:key], credentialStore.Credentials[key+1:]...)
+			err = l.saveCredentials(credentialStore)
+			if err != nil {
+				s.writeError(w, err)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+	}
+	s.writeError(w, notFound("credential %q not found", username))
+}
+
+func (s *Server) adminReserveCredential(w http.ResponseWriter, r *http.Request, l *lab) {
+	lease := l.newLease(r.FormValue("claim"), adminHolder)
+	s.adminUpdateCredential(w, r, l, func(cred *api.Credential) {
+		cred.Reserved = true
+		cred.Lease = lease
+	})
+}
+
+func (s *Server) adminUnreserveCredential(w http.ResponseWriter, r *http.Request, l *lab) {
+	s.adminUpdateCredential(w, r, l, func(cred *api.Credential) {
+		cred.Reserved = false
+		cred.Lease = nil
+	})
+}
+
+func (s *Server) adminAnnotateCredential(w http.ResponseWriter, r *http.Request, l *lab) {
+	metadata := r.FormValue("metadata")
+	s.adminUpdateCredential(w, r, l, func(cred *api.Credential) {
+		cred.Metadata = metadata
+	})
+}
+
+func (s *Server) adminUpdateCredential(w http.ResponseWriter, r *http.Request, l *lab, fn func(*api.Credential)) {
+	if r.Method != http.MethodPost {
+		s.writeError(w, errMethodNotAllowed)
+		return
+	}
+	username := r.FormValue("username")
+	if username == "" {
+		s.writeError(w, badRequest("username is required"))
+		return
+	}
+
+	var result api.Credential
+	found, err := l.updateCredential(username, func(cred *api.Credential) {
+		fn(cred)
+		result = *cred
+	})
+	if err != nil {
+		s.writeError(w, err)
+		return
+	}
+	if !found {
+		s.writeError(w, notFound("credential %q not found", username))
+		return
+	}
+	s.writeJSON(w, http.StatusOK, result)
+}
+
+// adminImportCredentials bulk imports credentials from a CSV, YAML or JSON
+// request body
+func (s *Server) adminImportCredentials(w http.ResponseWriter, r *http.Request, l *lab) {
+	l.log.Debug("adminImportCredentials")
+	if r.Method != http.MethodPost {
+		s.writeError(w, errMethodNotAllowed)
+		return
+	}
+
+	format := inventory.Format(r.URL.Query().Get("format"))
+	if format == "" {
+		format = formatFromContentType(r.Header.Get("Content-Type"))
+	}
+	mode, err := inventory.ParseMode(r.URL.Query().Get("mode"))
+	if err != nil {
+		s.writeError(w, badRequest("%s", err))
+		return
+	}
+
+	creds, err := inventory.ParseCredentials(r.Body, format)
+	if err != nil {
+		s.writeError(w, badRequest("%s", err))
+		return
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	credentialStore, err := l.loadCredentials()
+	if os.IsNotExist(err) {
+		credentialStore, err = &api.CredentialsStore{}, nil
+	}
+	if err != nil {
+		s.writeError(w, err)
+		return
+	}
+
+	result, err := inventory.ImportCredentials(credentialStore, creds, mode)
+	if err != nil {
+		s.writeError(w, conflict("%s", err))
+		return
+	}
+
+	err = l.saveCredentials(credentialStore)
+	if err != nil {
+		s.writeError(w, err)
+		return
+	}
+	l.waitlist.broadcast()
+	s.log.Infof("imported credentials: %d added, %d updated, %d removed", result.Added, result.Updated, result.Removed)
+	s.writeJSON(w, http.StatusOK, result)
+}
+
+func formatFromContentType(contentType string) inventory.Format {
+	switch {
+	case strings.Contains(contentType, "csv"):
+		return inventory.FormatCSV
+	case strings.Contains(contentType, "yaml"):
+		return inventory.FormatYAML
+	}
+	return inventory.FormatJSON
+}
+
+func (s *Server) adminWorkers(w http.ResponseWriter, r *http.Request, l *lab) {
+	l.log.Debug("adminWorkers")
+	switch r.Method {
+	case http.MethodGet:
+		s.listWorkers(w, r, l)
+	case http.MethodPost:
+		s.addWorkers(w, r, l)
+	case http.MethodDelete:
+		s.removeWorker(w, r, l)
+	default:
+		s.writeError(w, errMethodNotAllowed)
+	}
+}
+
+func (s *Server) listWorkers(w http.ResponseWriter, r *http.Request, l *lab) {
+	reserved, err := reservedFilter(r)
+	if err != nil {
+		s.writeError(w, badRequest("%s", err))
+		return
+	}
+
+	lock.Lock()
+	workerStore, err := l.loadWorkers()
+	lock.Unlock()
+	if err != nil {
+		s.writeError(w, err)
+		return
+	}
+
+	result := []api.Worker{}
+	for _, wk := range workerStore.Workers {
+		if reserved == nil || wk.Reserved == *reserved {
+			result = append(result, wk)
+		}
+	}
+	s.writeJSON(w, http.StatusOK, result)
+}
+
+// addWorkers is refused, workers are created by the worker backend
+func (s *Server) addWorkers(w http.ResponseWriter, r *http.Request, l *lab) {
+	s.writeError(w, badRequest("workers are provisioned by the worker backend, change workers.number instead"))
+}
+
+func (s *Server) removeWorker(w http.ResponseWriter, r *http.Request, l *lab) {
+	name := r.FormValue("name")
+	if name == "" {
+		s.writeError(w, badRequest("name is required"))
+		return
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	found, err := l.workerManager.Delete(name)
+	if err != nil {
+		s.writeError(w, err)
+		return
+	}
+	if !found {
+		s.writeError(w, notFound("worker %q not found", name))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) adminReserveWorker(w http.ResponseWriter, r *http.Request, l *lab) {
+	lease := l.newLease(r.FormValue("claim"), adminHolder)
+	s.adminUpdateWorker(w, r, l, func(wk *api.Worker) {
+		wk.Reserved = true
+		wk.Lease = lease
+	})
+}
+
+func (s *Server) adminUnreserveWorker(w http.ResponseWriter, r *http.Request, l *lab) {
+	s.adminUpdateWorker(w, r, l, func(wk *api.Worker) {
+		wk.Reserved = false
+		wk.Lease = nil
+	})
+}
+
+func (s *Server) adminAnnotateWorker(w http.ResponseWriter, r *http.Request, l *lab) {
+	metadata := r.FormValue("metadata")
+	s.adminUpdateWorker(w, r, l, func(wk *api.Worker) {
+		wk.Metadata = metadata
+	})
+}
+
+// adminRecycleWorker resets a worker to a pristine state with a new SSH key
+func (s *Server) adminRecycleWorker(w http.ResponseWriter, r *http.Request, l *lab) {
+	if r.Method != http.MethodPost {
+		s.writeError(w, errMethodNotAllowed)
+		return
+	}
+	name := r.FormValue("name")
+	if name == "" {
+		s.writeError(w, badRequest("name is required"))
+		return
+	}
+
+	found, err := l.workerManager.Recycle(name)
+	if err != nil {
+		s.writeError(w, err)
+		return
+	}
+	if !found {
+		s.writeError(w, notFound("worker %q not found", name))
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (s *Server) adminUpdateWorker(w http.ResponseWriter, r *http.Request, l *lab, fn func(*api.Worker)) {
+	if r.Method != http.MethodPost {
+		s.writeError(w, errMethodNotAllowed)
+		return
+	}
+	name := r.FormValue("name")
+	if name == "" {
+		s.writeError(w, badRequest("name is required"))
+		return
+	}
+
+	var result api.Worker
+	found, err := l.updateWorker(name, func(wk *api.Worker) {
+		fn(wk)
+		result = *wk
+	})
+	if err != nil {
+		s.writeError(w, err)
+		return
+	}
+	if !found {
+		s.writeError(w, notFound("worker %q not found", name))
+		return
+	}
+	s.writeJSON(w, http.StatusOK, result)
+}
+
+// adminExport exports the inventory and assignment history as JSON, or one
+// table of it as CSV
+func (s *Server) adminExport(w http.ResponseWriter, r *http.Request, l *lab) {
+	l.log.Debug("adminExport")
+	if r.Method != http.MethodGet {
+		s.writeError(w, errMethodNotAllowed)
+		return
+	}
+
+	report, err := l.loadReport(time.Now())
+	if err != nil {
+		s.writeError(w, err)
+		return
+	}
+
+	switch r.URL.Query().Get("format") {
+	case "", "json":
+		w.Header().Set("Content-Type", "application/json")
+		err = report.WriteJSON(w)
+	case "csv":
+		table := inventory.Table(r.URL.Query().Get("table"))
+		switch table {
+		case "":
+			table = inventory.TableAssignments
+		case inventory.TableAssignments, inventory.TableCredentials, inventory.TableWorkers:
+		default:
+			s.writeError(w, badRequest("unsupported table %q", table))
+			return
+		}
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s.csv", table))
+		err = report.WriteCSV(w, table)
+	default:
+		s.writeError(w, badRequest("format must be json or csv"))
+		return
+	}
+	if err != nil {
+		s.log.Error(err)
+	}
+}
+
+// reservedFilter parses the optional reserved query parameter
+func reservedFilter(r *http.Request) (*bool, error) {
+	value := r.URL.Query().Get("reserved")
+	if value == "" {
+		return nil, nil
+	}
+	reserved, err := strconv.ParseBool(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid reserved filter %q", value)
+	}
+	return &reserved, nil
+}
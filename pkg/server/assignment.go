@@ -0,0 +1,125 @@
+package server
+
+import (
+	"time"
+
+	"github.com/mjudeikis/osa-labs/pkg/api"
+	"github.com/mjudeikis/osa-labs/pkg/inventory"
+)
+
+const (
+	reasonReleased = "released"
+	reasonExpired  = "expired"
+)
+
+// recordAssignment appends an assignment to the history for the credential
+// and worker which are not open for the claim already. Caller must hold the
+// lock.
+func (l *lab) recordAssignment(assignment api.Assignment) error {
+	assignmentStore, err := l.loadAssignments()
+	if err != nil {
+		return err
+	}
+	if !addAssignment(assignmentStore, assignment) {
+		return nil
+	}
+	return l.saveAssignments(assignmentStore)
+}
+
+// closeAssignments marks the credential or worker as returned in the open
+// assignments holding them. Caller must hold the lock.
+func (l *lab) closeAssignments(username, worker, reason string, at time.Time) error {
+	assignmentStore, err := l.loadAssignments()
+	if err != nil {
+		return err
+	}
+	if !returnAssignments(assignmentStore, username, worker, reason, at) {
+		return nil
+	}
+	return l.saveAssignments(assignmentStore)
+}
+
+// returnAssignments marks the credential or worker as returned in the open
+// assignments holding them and returns whether any changed. When only one
+// side of a session is returned, the other side stays open in an assignment
+// of its own.
+func returnAssignments(assignmentStore *api.AssignmentsStore, username, worker, reason string, at time.Time) bool {
+	changed := false
+	// assignments split off below are open and not returned
+	n := len(assignmentStore.Assignments)
+	for key := 0; key < n; key++ {
+		a := assignmentStore.Assignments[key]
+		if a.ReleasedAt != nil {
+			continue
+		}
+		credentialReturned := username != "" && a.Username == username
+		workerReturned := worker != "" && a.Worker == worker
+		switch {
+		case !credentialReturned && !workerReturned:
+			continue
+		case credentialReturned && !workerReturned && a.Worker != "":
+			rest := a
+			rest.Username = ""
+			assignmentStore.Assignments[key].Worker = ""
+			assignmentStore.Assignments = append(assignmentStore.Assignments, rest)
+		case workerReturned && !credentialReturned && a.Username != "":
+			rest := a
+			rest.Worker = ""
+			assignmentStore.Assignments[key].Username = ""
+			assignmentStore.Assignments = append(assignmentStore.Assignments, rest)
+		}
+		assignmentStore.Assignments[key].ReleasedAt = &at
+		assignmentStore.Assignments[key].Reason = reason
+		changed = true
+	}
+	return changed
+}
+
+// trackReservation records the change of reservation state of a credential or
+// worker in the assignment history. Caller must hold the lock.
+func (l *lab) trackReservation(wasReserved, reserved bool, lease *api.Lease, username, worker string) error {
+	switch {
+	case !wasReserved && reserved && lease != nil:
+		return l.recordAssignment(api.Assignment{
+			Claim:      lease.Claim,
+			Holder:     lease.Holder,
+			Username:   username,
+			Worker:     worker,
+			AssignedAt: lease.ReservedAt,
+		})
+	case wasReserved && !reserved:
+		l.waitlist.broadcast()
+		return l.closeAssignments(username, worker, reasonReleased, time.Now().UTC())
+	}
+	return nil
+}
+
+// addAssignment appends the assignment for the credential and worker which
+// are not open for the claim already, e.g. for repeated requests, and returns
+// whether it appended one
+func addAssignment(assignmentStore *api.AssignmentsStore, assignment api.Assignment) bool {
+	for _, a := range assignmentStore.Assignments {
+		if a.ReleasedAt != nil || a.Claim != assignment.Claim {
+			continue
+		}
+		if assignment.Username != "" && a.Username == assignment.Username {
+			assignment.Username = ""
+		}
+		if assignment.Worker != "" && a.Worker == assignment.Worker {
+			assignment.Worker = ""
+		}
+	}
+	if assignment.Username == "" && assignment.Worker == "" {
+		return false
+	}
+	assignmentStore.Assignments = append(assignmentStore.Assignments, assignment)
+	return true
+}
+
+func (l *lab) loadAssignments() (*api.AssignmentsStore, error) {
+	return inventory.LoadAssignments(l.store)
+}
+
+func (l *lab) saveAssignments(assignmentStore *api.AssignmentsStore) error {
+	return inventory.SaveAssignments(l.store, assignmentStore)
+}
@@ -0,0 +1,55 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/mjudeikis/osa-labs/pkg/utils/random"
+)
+
+const (
+	claimHeader = "X-Claim-ID"
+	claimCookie = "osa-labs-claim"
+	claimParam  = "claim"
+)
+
+// claimID returns the participant claim ID sent with the request, if any.
+// Claims are looked up in the header, query and cookie in that order.
+func claimID(r *http.Request) string {
+	if claim := r.Header.Get(claimHeader); claim != "" {
+		return claim
+	}
+	if claim := r.URL.Query().Get(claimParam); claim != "" {
+		return claim
+	}
+	if c, err := r.Cookie(claimCookie); err == nil {
+		return c.Value
+	}
+	return ""
+}
+
+func newClaimID() (string, error) {
+	return random.AlphanumericString(20)
+}
+
+// participant returns the claim ID identifying the participant. If the
+// request carries none, a new one is generated and handed back to the client
+// as a header and cookie so repeated requests return the same assignment.
+func (s *Server) participant(w http.ResponseWriter, r *http.Request) (string, error) {
+	claim := claimID(r)
+	if claim != "" {
+		return claim, nil
+	}
+
+	claim, err := newClaimID()
+	if err != nil {
+		return "", err
+	}
+	w.Header().Set(claimHeader, claim)
+	http.SetCookie(w, &http.Cookie{
+		Name:     claimCookie,
+		Value:    claim,
+		Path:     "/",
+		HttpOnly: true,
+	})
+	return claim, nil
+}
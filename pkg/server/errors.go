@@ -0,0 +1,106 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/mjudeikis/osa-labs/pkg/workers"
+)
+
+// Error is an API error returned to clients as a JSON document
+type Error struct {
+	Status     int    `json:"-"`
+	Code       string `json:"code"`
+	Message    string `json:"message"`
+	RetryAfter int    `json:"retryAfter,omitempty"`
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+var (
+	errMethodNotAllowed = &Error{
+		Status:  http.StatusMethodNotAllowed,
+		Code:    "MethodNotAllowed",
+		Message: "method not allowed",
+	}
+	errUnauthorized = &Error{
+		Status:  http.StatusUnauthorized,
+		Code:    "Unauthorized",
+		Message: "missing or invalid admin token",
+	}
+	errForbidden = &Error{
+		Status:  http.StatusForbidden,
+		Code:    "Forbidden",
+		Message: "the lease is held by another participant",
+	}
+	errCredentialsExhausted = &Error{
+		Status:     http.StatusServiceUnavailable,
+		Code:       "CredentialsExhausted",
+		Message:    "all credentials are handed out, please ask the organisers for help",
+		RetryAfter: 30,
+	}
+	errWorkersExhausted = &Error{
+		Status:     http.StatusServiceUnavailable,
+		Code:       "WorkersExhausted",
+		Message:    "all workers are handed out, please ask the organisers for help",
+		RetryAfter: 30,
+	}
+)
+
+func badRequest(format string, args ...interface{}) *Error {
+	return &Error{Status: http.StatusBadRequest, Code: "BadRequest", Message: fmt.Sprintf(format, args...)}
+}
+
+func notFound(format string, args ...interface{}) *Error {
+	return &Error{Status: http.StatusNotFound, Code: "NotFound", Message: fmt.Sprintf(format, args...)}
+}
+
+func conflict(format string, args ...interface{}) *Error {
+	return &Error{Status: http.StatusConflict, Code: "Conflict", Message: fmt.Sprintf(format, args...)}
+}
+
+// writeError writes err as a JSON error document. Errors which are not an
+// *Error are logged and reported as internal errors, apart from missing store
+// records which are reported as not found and operations the worker backend
+// does not support, which are reported as bad requests.
+func (s *Server) writeError(w http.ResponseWriter, err error) {
+	apiErr, ok := err.(*Error)
+	switch {
+	case ok:
+	case err == workers.ErrUnsupported:
+		apiErr = badRequest("%v", err)
+	case os.IsNotExist(err):
+		s.log.Warn(err)
+		apiErr = notFound("requested record does not exist in the store")
+	default:
+		s.log.Error(err)
+		apiErr = &Error{
+			Status:  http.StatusInternalServerError,
+			Code:    "InternalError",
+			Message: err.Error(),
+		}
+	}
+
+	res, err := json.Marshal(struct {
+		Error *Error `json:"error"`
+	}{
+		Error: apiErr,
+	})
+	if err != nil {
+		s.log.Error(err)
+		http.Error(w, "500 Internal Error", http.StatusInternalServerError)
+		return
+	}
+
+	if apiErr.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(apiErr.RetryAfter))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(apiErr.Status)
+	w.Write(res)
+}
@@ -0,0 +1,152 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/subtle"
+	"io/ioutil"
+	"os"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/mjudeikis/osa-labs/pkg/api"
+	"github.com/mjudeikis/osa-labs/pkg/config"
+	labssh "github.com/mjudeikis/osa-labs/pkg/ssh"
+	"github.com/mjudeikis/osa-labs/pkg/store"
+	"github.com/mjudeikis/osa-labs/pkg/utils/keygen"
+)
+
+// gatewayResolver finds the workers of participants connecting through the
+// SSH gateway across all labs
+type gatewayResolver struct {
+	s *Server
+}
+
+var _ labssh.Resolver = &gatewayResolver{}
+
+// ByKey resolves a participant logging in as their worker name with the
+// worker key
+func (g *gatewayResolver) ByKey(user string, key ssh.PublicKey) (*labssh.Target, error) {
+	for _, l := range g.s.labs {
+		workers, err := l.workerManager.List()
+		if err != nil {
+			return nil, err
+		}
+		for i := range workers {
+			wk := &workers[i]
+			if wk.Name != user || !wk.Reserved {
+				continue
+			}
+			signer, err := ssh.ParsePrivateKey([]byte(wk.SSHKey))
+			if err != nil {
+				return nil, err
+			}
+			if !bytes.Equal(signer.PublicKey().Marshal(), key.Marshal()) {
+				return nil, nil
+			}
+			return gatewayTarget(wk), nil
+		}
+	}
+	return nil, nil
+}
+
+// ByPassword resolves a participant logging in with their credential. The
+// worker is the one held by the same claim.
+func (g *gatewayResolver) ByPassword(user, password string) (*labssh.Target, error) {
+	for _, l := range g.s.labs {
+		claim, err := l.credentialClaim(user, password)
+		if err != nil {
+			return nil, err
+		}
+		if claim == "" {
+			continue
+		}
+		session, err := l.findSession(claim)
+		if err != nil {
+			return nil, err
+		}
+		if session == nil || session.Worker == nil {
+			return nil, nil
+		}
+		return gatewayTarget(session.Worker), nil
+	}
+	return nil, nil
+}
+
+// credentialClaim returns the claim holding the credential with the username
+// and password, or an empty string if there is none
+func (l *lab) credentialClaim(username, password string) (string, error) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	credentialStore, err := l.loadCredentials()
+	if err != nil {
+		return "", err
+	}
+	for _, cred := range credentialStore.Credentials {
+		if cred.Username != username || !cred.Reserved || cred.Lease == nil {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(cred.Password), []byte(password)) == 1 {
+			return cred.Lease.Claim, nil
+		}
+	}
+	return "", nil
+}
+
+func gatewayTarget(wk *api.Worker) *labssh.Target {
+	return &labssh.Target{
+		Address: wk.Address,
+		User:    wk.User,
+		Key:     wk.SSHKey,
+	}
+}
+
+// runGateway serves the SSH gateway until the context is done
+func (s *Server) runGateway(ctx context.Context) error {
+	hostKey, err := gatewayHostKey(s.log, s.config)
+	if err != nil {
+		return err
+	}
+	log := s.log.WithField("component", "gateway")
+	return labssh.NewGateway(log, s.config.Gateway.Address, hostKey, &gatewayResolver{s: s}).Run(ctx)
+}
+
+// gatewayHostKey reads the configured host key. Without one a key is
+// generated once and kept in the storage directory, so participants do not
+// see the host key change on every restart.
+func gatewayHostKey(log *logrus.Entry, cfg *config.Config) (ssh.Signer, error) {
+	if cfg.Gateway.HostKey != "" {
+		b, err := ioutil.ReadFile(cfg.Gateway.HostKey)
+		if err != nil {
+			return nil, err
+		}
+		return ssh.ParsePrivateKey(b)
+	}
+
+	st, err := store.New(log, cfg.StorageDir, config.GatewayStoreNamespace)
+	if err != nil {
+		return nil, err
+	}
+	b, err := st.Get("host_key")
+	if os.IsNotExist(err) {
+		log.Info("generating SSH gateway host key")
+		var key *rsa.PrivateKey
+		key, err = rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return nil, err
+		}
+		b, err = keygen.PrivateKeyAsBytes(key)
+		if err != nil {
+			return nil, err
+		}
+		err = st.Put("host_key", b)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ssh.ParsePrivateKey(b)
+}
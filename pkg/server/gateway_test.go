@@ -0,0 +1,90 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/mjudeikis/osa-labs/pkg/api"
+	"github.com/mjudeikis/osa-labs/pkg/utils/keygen"
+	"github.com/mjudeikis/osa-labs/pkg/workers"
+)
+
+func newTestKey(t *testing.T) (string, ssh.PublicKey) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := keygen.PrivateKeyAsBytes(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.ParsePrivateKey(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b), signer.PublicKey()
+}
+
+func TestGatewayResolver(t *testing.T) {
+	key, public := newTestKey(t)
+	_, otherPublic := newTestKey(t)
+	wm := workers.NewMemory(
+		api.Worker{Name: "a", Address: "172.30.0.1:2222", User: "a", SSHKey: key},
+		api.Worker{Name: "b", Address: "172.30.0.2:2222", User: "b", SSHKey: key},
+	)
+	s, l := newTestServer(t, wm,
+		api.Credential{Username: "u1", Password: "p1"},
+		api.Credential{Username: "u2", Password: "p2"},
+	)
+	_, err := l.reserveSession("c1", "test", turn{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := &gatewayResolver{s: s}
+
+	target, err := g.ByKey("a", public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if target == nil || target.Address != "172.30.0.1:2222" || target.User != "a" || target.Key != key {
+		t.Errorf("unexpected target %#v", target)
+	}
+	for _, tt := range []struct {
+		user string
+		key  ssh.PublicKey
+	}{
+		{user: "a", key: otherPublic},
+		// b is not handed out
+		{user: "b", key: public},
+	} {
+		target, err := g.ByKey(tt.user, tt.key)
+		if err != nil || target != nil {
+			t.Errorf("expected %s to be rejected, got %#v, %v", tt.user, target, err)
+		}
+	}
+
+	target, err = g.ByPassword("u1", "p1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if target == nil || target.Address != "172.30.0.1:2222" {
+		t.Errorf("unexpected target %#v", target)
+	}
+	for _, tt := range []struct {
+		user     string
+		password string
+	}{
+		{user: "u1", password: "wrong"},
+		// u2 is not handed out
+		{user: "u2", password: "p2"},
+	} {
+		target, err := g.ByPassword(tt.user, tt.password)
+		if err != nil || target != nil {
+			t.Errorf("expected %s to be rejected, got %#v, %v", tt.user, target, err)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/mjudeikis/osa-labs/pkg/utils/wait"
+)
+
+// provisionRetryInterval is how long to wait before retrying failed worker
+// provisioning
+const provisionRetryInterval = 30 * time.Second
+
+// labHealth is the readiness of one lab as reported by /readyz
+type labHealth struct {
+	Name   string `json:"name"`
+	Ready  bool   `json:"ready"`
+	Reason string `json:"reason,omitempty"`
+}
+
+type health struct {
+	Ready bool        `json:"ready"`
+	Labs  []labHealth `json:"labs"`
+}
+
+// provisioning tracks whether the workers of a lab have been created
+type provisioning struct {
+	sync.Mutex
+	done bool
+	err  error
+}
+
+func (p *provisioning) set(done bool, err error) {
+	p.Lock()
+	defer p.Unlock()
+	p.done, p.err = done, err
+}
+
+func (p *provisioning) get() (bool, error) {
+	p.Lock()
+	defer p.Unlock()
+	return p.done, p.err
+}
+
+// provision creates the workers of the lab, retrying until it succeeds or the
+// context is done. Participants are served from the existing pools meanwhile.
+func (l *lab) provision(ctx context.Context) {
+	wait.PollImmediateUntil(provisionRetryInterval, func() (bool, error) {
+		err := l.workerManager.Create(ctx)
+		if err != nil {
+			l.log.Errorf("provisioning workers: %v", err)
+			l.provisioning.set(false, err)
+			return false, nil
+		}
+		l.log.Info("workers provisioned")
+		l.provisioning.set(true, nil)
+		l.waitlist.broadcast()
+		l.requestScale()
+		return true, nil
+	}, ctx.Done())
+}
+
+// health returns whether the lab can hand out credentials and workers
+func (l *lab) health() labHealth {
+	h := labHealth{Name: l.Name}
+
+	done, err := l.provisioning.get()
+	switch {
+	case err != nil:
+		h.Reason = "provisioning workers failed: " + err.Error()
+		return h
+	case !done:
+		h.Reason = "provisioning workers"
+		return h
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	credentialStore, err := l.loadCredentials()
+	if err != nil {
+		h.Reason = "loading credentials: " + err.Error()
+		return h
+	}
+	workerStore, err := l.loadWorkers()
+	if err != nil {
+		h.Reason = "loading workers: " + err.Error()
+		return h
+	}
+	switch {
+	case len(credentialStore.Credentials) == 0:
+		h.Reason = "no credentials in the pool"
+	case len(workerStore.Workers) == 0:
+		h.Reason = "no workers in the pool"
+	default:
+		h.Ready = true
+	}
+	return h
+}
+
+// healthz reports the process is alive
+func (s *Server) healthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte("ok\n"))
+}
+
+// readyz reports whether all labs can serve participants. It fails while the
+// server shuts down so no new traffic is routed to it.
+func (s *Server) readyz(w http.ResponseWriter, r *http.Request) {
+	h := health{Ready: !s.isStopping()}
+	for _, l := range s.labs {
+		lh := l.health()
+		h.Ready = h.Ready && lh.Ready
+		h.Labs = append(h.Labs, lh)
+	}
+
+	status := http.StatusOK
+	if !h.Ready {
+		status = http.StatusServiceUnavailable
+	}
+	s.writeJSON(w, status, h)
+}
+
+func (s *Server) isStopping() bool {
+	select {
+	case <-s.stopping:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,142 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/mjudeikis/osa-labs/pkg/api"
+	"github.com/mjudeikis/osa-labs/pkg/config"
+	"github.com/mjudeikis/osa-labs/pkg/store"
+	"github.com/mjudeikis/osa-labs/pkg/workers"
+)
+
+// lab holds the pools and worker manager of one configured lab
+type lab struct {
+	api.Lab
+	log           *logrus.Entry
+	store         store.Store
+	workerManager workers.Workers
+	provisioning  provisioning
+	waitlist      *waitlist
+	leaseTTL      time.Duration
+
+	// scale triggers an autoscaling pass ahead of the interval
+	scale chan struct{}
+}
+
+type labHandler func(w http.ResponseWriter, r *http.Request, l *lab)
+
+func newLab(log *logrus.Entry, cfg *config.Config, l api.Lab) (*lab, error) {
+	log = log.WithField("lab", l.Name)
+
+	store, err := store.New(log, cfg.StorageDir, l.StoreNamespace)
+	if err != nil {
+		return nil, err
+	}
+
+	wm, err := workers.New(log, store, l.WorkerNamespace, cfg.LabWorkers(&l))
+	if err != nil {
+		return nil, err
+	}
+
+	return &lab{
+		Lab:           l,
+		log:           log,
+		store:         store,
+		workerManager: wm,
+		waitlist:      newWaitlist(),
+		leaseTTL:      cfg.LeaseTTL.Duration,
+		scale:         make(chan struct{}, 1),
+	}, nil
+}
+
+type labLink struct {
+	Name  string
+	Title string
+	URL   string
+}
+
+// templateData is passed to the index page and setup script templates
+type templateData struct {
+	Title        string
+	Logo         string
+	Instructions string
+	Welcome      string
+	Hostname     string
+	Static       string
+	Claim        string
+	// Port is the SSH port of the session worker, or the configured worker
+	// port without one
+	Port    int32
+	Session *api.Session
+	Waiting *api.Waiting
+	SSHHost string
+	Labs    []labLink
+}
+
+func (s *Server) templateData(l *lab) *templateData {
+	data := &templateData{
+		Title:        l.Title,
+		Logo:         l.Logo,
+		Instructions: l.Instructions,
+		Welcome:      l.Welcome,
+		Hostname:     s.labURL(l),
+		Static:       s.hostname + "/static",
+		Port:         s.config.Workers.Port,
+		SSHHost:      "osa-labs-" + l.Name,
+	}
+	if data.Logo == "" {
+		data.Logo = data.Static + "/logo.svg"
+	}
+	return data
+}
+
+// setSession adds the session of the participant
+func (data *templateData) setSession(session *api.Session) {
+	data.Session = session
+	if session != nil && session.Worker != nil && session.Worker.Port != 0 {
+		data.Port = session.Worker.Port
+	}
+}
+
+// labURL returns the URL the lab is served at. The first lab is also served
+// at the root.
+func (s *Server) labURL(l *lab) string {
+	if l == s.labs[0] {
+		return s.hostname
+	}
+	return s.hostname + "/labs/" + l.Name
+}
+
+// withLab serves h for a fixed lab
+func (s *Server) withLab(l *lab, h labHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		h(w, r, l)
+	}
+}
+
+// routeLabs serves /labs/{name}/{path} with the handler registered for path
+func (s *Server) routeLabs(handlers map[string]labHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/labs/"), "/", 2)
+		l, ok := s.labsByName[parts[0]]
+		if !ok {
+			s.writeError(w, notFound("lab %q not found", parts[0]))
+			return
+		}
+
+		path := "/"
+		if len(parts) == 2 {
+			path += parts[1]
+		}
+		h, ok := handlers[path]
+		if !ok {
+			s.writeError(w, notFound("%s not found", r.URL.Path))
+			return
+		}
+		h(w, r, l)
+	}
+}
@@ -0,0 +1,272 @@
+package server
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	"github.com/mjudeikis/osa-labs/pkg/api"
+)
+
+const leaseReconcileInterval = time.Minute
+
+// holder identifies the participant making the request by address. The
+// X-Forwarded-For header can be set by any client, so its first hop is only
+// used if the request comes from a trusted proxy.
+func (s *Server) holder(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	fwd := r.Header.Get("X-Forwarded-For")
+	if fwd == "" || !s.trustedProxy(host) {
+		return host
+	}
+	if client := strings.TrimSpace(strings.Split(fwd, ",")[0]); client != "" {
+		return client
+	}
+	return host
+}
+
+func (s *Server) trustedProxy(host string) bool {
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, network := range s.trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (l *lab) newLease(claim, holder string) *api.Lease {
+	now := time.Now().UTC()
+	lease := &api.Lease{
+		Claim:      claim,
+		Holder:     holder,
+		ReservedAt: now,
+	}
+	if l.leaseTTL > 0 {
+		lease.ExpiresAt = now.Add(l.leaseTTL)
+	}
+	return lease
+}
+
+// release returns the credential and/or worker named in the request back to
+// the pool. Participants can only release their own leases, admins any.
+func (s *Server) release(w http.ResponseWriter, r *http.Request, l *lab) {
+	l.log.Debug("release")
+	if r.Method != http.MethodPost {
+		s.writeError(w, errMethodNotAllowed)
+		return
+	}
+
+	username := r.FormValue("credential")
+	name := r.FormValue("worker")
+	if username == "" && name == "" {
+		s.writeError(w, badRequest("credential or worker is required"))
+		return
+	}
+
+	claim := claimID(r)
+	admin := s.authorized(r)
+	owner := func(lease *api.Lease) bool {
+		return admin || (claim != "" && lease != nil && lease.Claim == claim)
+	}
+
+	err := l.releaseLeases(username, name, owner)
+	if err != nil {
+		s.writeError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// releaseLeases frees the named credential and worker. Both are checked
+// before either is changed, so nothing is released if one of them is missing
+// or owner does not accept its lease.
+func (l *lab) releaseLeases(username, name string, owner func(*api.Lease) bool) error {
+	lock.Lock()
+	defer lock.Unlock()
+
+	var credentialStore *api.CredentialsStore
+	var cred *api.Credential
+	if username != "" {
+		var err error
+		credentialStore, err = l.loadCredentials()
+		if err != nil {
+			return err
+		}
+		for i := range credentialStore.Credentials {
+			if credentialStore.Credentials[i].Username == username {
+				cred = &credentialStore.Credentials[i]
+				break
+			}
+		}
+		if cred == nil {
+			return notFound("credential %q not found", username)
+		}
+		if cred.Reserved && !owner(cred.Lease) {
+			return errForbidden
+		}
+	}
+
+	if name != "" {
+		workers, err := l.workerManager.List()
+		if err != nil {
+			return err
+		}
+		var wk *api.Worker
+		for i := range workers {
+			if workers[i].Name == name {
+				wk = &workers[i]
+				break
+			}
+		}
+		if wk == nil {
+			return notFound("worker %q not found", name)
+		}
+		if wk.Reserved && !owner(wk.Lease) {
+			return errForbidden
+		}
+	}
+
+	if cred != nil {
+		wasReserved := cred.Reserved
+		cred.Reserved = false
+		cred.Lease = nil
+		err := l.saveCredentials(credentialStore)
+		if err != nil {
+			return err
+		}
+		err = l.trackReservation(wasReserved, false, nil, username, "")
+		if err != nil {
+			return err
+		}
+	}
+
+	if name != "" {
+		var wasReserved, forbidden bool
+		wk, err := l.workerManager.Update(name, func(wk *api.Worker) {
+			wasReserved = wk.Reserved
+			// the worker may have been reserved again by another replica
+			forbidden = wk.Reserved && !owner(wk.Lease)
+			if forbidden {
+				return
+			}
+			wk.Reserved = false
+			wk.Lease = nil
+		})
+		if err != nil {
+			return err
+		}
+		if wk == nil {
+			return notFound("worker %q not found", name)
+		}
+		if forbidden {
+			return errForbidden
+		}
+		return l.trackReservation(wasReserved, false, nil, "", name)
+	}
+	return nil
+}
+
+// reconcileLeases periodically returns credentials and workers with expired
+// leases back to the pool
+func (l *lab) reconcileLeases(ctx context.Context) {
+	wait.Until(func() {
+		err := l.expireLeases(time.Now())
+		if err != nil {
+			l.log.Error(err)
+		}
+	}, leaseReconcileInterval, ctx.Done())
+}
+
+func (l *lab) expireLeases(now time.Time) error {
+	lock.Lock()
+	defer lock.Unlock()
+
+	credentialStore, err := l.loadCredentials()
+	if err != nil {
+		return err
+	}
+	assignmentStore, err := l.loadAssignments()
+	if err != nil {
+		return err
+	}
+	// the history is saved once for all expired leases
+	returned := false
+	expired := 0
+	for key, cred := range credentialStore.Credentials {
+		if cred.Reserved && cred.Lease.Expired(now) {
+			l.log.Infof("credential %s lease held by %s expired", cred.Username, cred.Lease.Holder)
+			credentialStore.Credentials[key].Reserved = false
+			credentialStore.Credentials[key].Lease = nil
+			expired++
+			if returnAssignments(assignmentStore, cred.Username, "", reasonExpired, now.UTC()) {
+				returned = true
+			}
+		}
+	}
+	if expired > 0 {
+		err = l.saveCredentials(credentialStore)
+		if err != nil {
+			return err
+		}
+	}
+
+	workerStore, err := l.loadWorkers()
+	if err != nil {
+		return err
+	}
+	for _, wk := range workerStore.Workers {
+		if !wk.Reserved || !wk.Lease.Expired(now) {
+			continue
+		}
+		// the lease may have been renewed since the workers were listed
+		var holder string
+		updated, err := l.workerManager.Update(wk.Name, func(current *api.Worker) {
+			holder = ""
+			if current.Reserved && current.Lease.Expired(now) {
+				holder = current.Lease.Holder
+				current.Reserved = false
+				current.Lease = nil
+			}
+		})
+		if err != nil {
+			// keep the history of the leases expired so far
+			if returned {
+				if serr := l.saveAssignments(assignmentStore); serr != nil {
+					l.log.Error(serr)
+				}
+			}
+			return err
+		}
+		if updated == nil || holder == "" {
+			continue
+		}
+		l.log.Infof("worker %s lease held by %s expired", wk.Name, holder)
+		expired++
+		if returnAssignments(assignmentStore, "", wk.Name, reasonExpired, now.UTC()) {
+			returned = true
+		}
+	}
+
+	if returned {
+		err = l.saveAssignments(assignmentStore)
+		if err != nil {
+			return err
+		}
+	}
+	if expired > 0 {
+		l.waitlist.broadcast()
+	}
+	return nil
+}
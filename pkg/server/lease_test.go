@@ -0,0 +1,146 @@
+package server
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/mjudeikis/osa-labs/pkg/api"
+	"github.com/mjudeikis/osa-labs/pkg/workers"
+)
+
+func TestHolder(t *testing.T) {
+	_, proxies, err := net.ParseCIDR("10.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{trustedProxies: []*net.IPNet{proxies}}
+
+	for _, tt := range []struct {
+		name       string
+		remoteAddr string
+		forwarded  string
+		want       string
+	}{
+		{name: "direct", remoteAddr: "192.0.2.1:1234", want: "192.0.2.1"},
+		{name: "untrusted proxy", remoteAddr: "192.0.2.1:1234", forwarded: "198.51.100.1", want: "192.0.2.1"},
+		{name: "trusted proxy", remoteAddr: "10.1.2.3:1234", forwarded: "198.51.100.1", want: "198.51.100.1"},
+		{name: "first hop", remoteAddr: "10.1.2.3:1234", forwarded: "198.51.100.1, 10.4.5.6", want: "198.51.100.1"},
+		{name: "empty first hop", remoteAddr: "10.1.2.3:1234", forwarded: ", 10.4.5.6", want: "10.1.2.3"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/worker", nil)
+			r.RemoteAddr = tt.remoteAddr
+			if tt.forwarded != "" {
+				r.Header.Set("X-Forwarded-For", tt.forwarded)
+			}
+			if got := s.holder(r); got != tt.want {
+				t.Errorf("expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestExpireLeases(t *testing.T) {
+	wm := workers.NewMemory(api.Worker{Name: "a"})
+	_, l := newTestServer(t, wm, api.Credential{Username: "u1", Password: "p1"})
+	_, err := l.reserveSession("c1", "test", turn{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// only the credential lease runs out
+	credentialStore, err := l.loadCredentials()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expiresAt := time.Now().Add(time.Hour)
+	credentialStore.Credentials[0].Lease.ExpiresAt = expiresAt.Add(-time.Minute)
+	err = l.saveCredentials(credentialStore)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = wm.Update("a", func(wk *api.Worker) {
+		wk.Lease.ExpiresAt = expiresAt.Add(time.Minute)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = l.expireLeases(expiresAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	credentialStore, err = l.loadCredentials()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if credentialStore.Credentials[0].Reserved {
+		t.Error("expected the credential to be released")
+	}
+	session, err := l.findSession("c1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session == nil || session.Worker == nil {
+		t.Fatal("expected the worker to stay leased")
+	}
+
+	assignments, err := l.loadAssignments()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(assignments.Assignments) != 2 {
+		t.Fatalf("expected the session to be split, got %#v", assignments.Assignments)
+	}
+	credential, worker := assignments.Assignments[0], assignments.Assignments[1]
+	if credential.Username != "u1" || credential.Worker != "" || credential.ReleasedAt == nil || credential.Reason != reasonExpired {
+		t.Errorf("expected the credential to be returned, got %#v", credential)
+	}
+	if worker.Worker != "a" || worker.Username != "" || worker.ReleasedAt != nil || worker.Claim != "c1" {
+		t.Errorf("expected the worker to stay assigned, got %#v", worker)
+	}
+
+	// a repeated request of the participant only records the new credential
+	_, err = l.reserveSession("c1", "test", turn{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assignments, err = l.loadAssignments()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(assignments.Assignments) != 3 || assignments.Assignments[2].Username != "u1" || assignments.Assignments[2].Worker != "" {
+		t.Errorf("unexpected assignments %#v", assignments.Assignments)
+	}
+}
+
+func TestReleaseChecksBoth(t *testing.T) {
+	wm := workers.NewMemory(api.Worker{Name: "a"})
+	s, l := newTestServer(t, wm, api.Credential{Username: "u1", Password: "p1"})
+	_, err := l.getUniqueCredential("c1", "test", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = l.getUniqueWorker("c2", "test", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := serve(s, l, s.release, http.MethodPost, "/release?claim=c1", url.Values{"credential": {"u1"}, "worker": {"a"}})
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("expected 403, got %d: %s", w.Code, w.Body.String())
+	}
+
+	credentialStore, err := l.loadCredentials()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !credentialStore.Credentials[0].Reserved {
+		t.Error("expected the credential to stay reserved when the worker may not be released")
+	}
+}
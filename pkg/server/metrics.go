@@ -0,0 +1,85 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/mjudeikis/osa-labs/pkg/inventory"
+	"github.com/mjudeikis/osa-labs/pkg/metrics"
+)
+
+// poolCollector reports the pool sizes of all labs, read at scrape time
+type poolCollector struct {
+	s *Server
+}
+
+var _ prometheus.Collector = &poolCollector{}
+
+func (c *poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- metrics.PoolDesc
+}
+
+func (c *poolCollector) Collect(ch chan<- prometheus.Metric) {
+	now := time.Now()
+	for _, l := range c.s.labs {
+		report, err := l.loadReport(now)
+		if err != nil {
+			l.log.Warnf("collecting pool metrics: %v", err)
+			continue
+		}
+
+		for pool, summary := range map[string]inventory.Summary{
+			"credentials": report.CredentialsSummary,
+			"workers":     report.WorkersSummary,
+		} {
+			for state, n := range map[string]int{
+				"total":    summary.Total,
+				"free":     summary.Free,
+				"reserved": summary.Reserved,
+				"expired":  summary.Expired,
+			} {
+				ch <- prometheus.MustNewConstMetric(metrics.PoolDesc, prometheus.GaugeValue, float64(n), l.Name, pool, state)
+			}
+		}
+	}
+}
+
+// instrument records latency and errors of a handler
+func (s *Server) instrument(path string, h labHandler) labHandler {
+	return func(w http.ResponseWriter, r *http.Request, l *lab) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		h(rec, r, l)
+
+		metrics.RequestDuration.WithLabelValues(l.Name, path, r.Method).Observe(time.Since(start).Seconds())
+		if rec.status >= http.StatusBadRequest {
+			metrics.RequestErrors.WithLabelValues(l.Name, path, strconv.Itoa(rec.status)).Inc()
+		}
+	}
+}
+
+// statusRecorder remembers the status written by a handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (rec *statusRecorder) WriteHeader(status int) {
+	rec.status = status
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+// Hijack lets the web terminal take over the connection
+func (rec *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := rec.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("response writer does not support hijacking")
+	}
+	return hijacker.Hijack()
+}
@@ -0,0 +1,138 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/mjudeikis/osa-labs/pkg/api"
+)
+
+// participantPageRefresh is how often the participant page reloads while the
+// participant is queued
+const participantPageRefresh = 10
+
+// participantPage is the browser self-service flow. GET shows the session held
+// by the claim, POST reserves one and redirects to the bookmarkable claim link.
+func (s *Server) participantPage(w http.ResponseWriter, r *http.Request, l *lab) {
+	l.log.Debug("participantPage")
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		s.participantReserve(w, r, l)
+		return
+	default:
+		s.writeError(w, errMethodNotAllowed)
+		return
+	}
+
+	data := s.templateData(l)
+	data.Claim = claimID(r)
+	if data.Claim != "" {
+		session, err := l.findSession(data.Claim)
+		if err != nil {
+			s.writeError(w, err)
+			return
+		}
+		data.setSession(session)
+
+		// a queued participant keeps its place by reloading the page
+		if session == nil && l.waitlist.queued(data.Claim) {
+			s.participantReserve(w, r, l)
+			return
+		}
+	}
+	s.writeTemplate(w, http.StatusOK, "lab.html", data)
+}
+
+func (s *Server) participantReserve(w http.ResponseWriter, r *http.Request, l *lab) {
+	claim, err := s.participant(w, r)
+	if err != nil {
+		s.writeError(w, err)
+		return
+	}
+
+	_, waiting, err := s.waitForTurn(r, l, claim, sessionPools, func(ahead turn) (interface{}, error) {
+		return l.reserveSession(claim, s.holder(r), ahead)
+	})
+	switch {
+	case err != nil:
+		s.writeError(w, err)
+	case waiting != nil:
+		data := s.templateData(l)
+		data.Claim = claim
+		data.Waiting = waiting
+		w.Header().Set("Refresh", fmt.Sprintf("%d; url=%s", participantPageRefresh, s.participantURL(l, "/lab", claim)))
+		s.writeTemplate(w, http.StatusAccepted, "lab.html", data)
+	default:
+		http.Redirect(w, r, s.participantURL(l, "/lab", claim), http.StatusSeeOther)
+	}
+}
+
+// participantKey downloads the SSH private key of the claim's worker
+func (s *Server) participantKey(w http.ResponseWriter, r *http.Request, l *lab) {
+	l.log.Debug("participantKey")
+
+	session, err := s.participantSession(r, l)
+	if err != nil {
+		s.writeError(w, err)
+		return
+	}
+	s.writeAttachment(w, s.templateData(l).SSHHost+"_id_rsa", []byte(session.Worker.SSHKey))
+}
+
+// participantSSHConfig downloads an ~/.ssh/config snippet for the claim's
+// worker
+func (s *Server) participantSSHConfig(w http.ResponseWriter, r *http.Request, l *lab) {
+	l.log.Debug("participantSSHConfig")
+
+	session, err := s.participantSession(r, l)
+	if err != nil {
+		s.writeError(w, err)
+		return
+	}
+
+	data := s.templateData(l)
+	data.Claim = session.Claim
+	data.setSession(session)
+
+	var buf bytes.Buffer
+	err = s.templates.Execute(&buf, "ssh_config", data)
+	if err != nil {
+		s.writeError(w, err)
+		return
+	}
+	s.writeAttachment(w, "config", buf.Bytes())
+}
+
+// participantSession returns the session of the claim sent with the request.
+// It fails if the claim holds no worker.
+func (s *Server) participantSession(r *http.Request, l *lab) (*api.Session, error) {
+	claim := claimID(r)
+	if claim == "" {
+		return nil, badRequest("claim is required")
+	}
+	session, err := l.findSession(claim)
+	if err != nil {
+		return nil, err
+	}
+	if session == nil || session.Worker == nil {
+		return nil, notFound("claim %q holds no worker", claim)
+	}
+	return session, nil
+}
+
+// participantURL returns the bookmarkable link of a participant page
+func (s *Server) participantURL(l *lab, path, claim string) string {
+	query := url.Values{}
+	query.Set(claimParam, claim)
+	return s.labURL(l) + path + "?" + query.Encode()
+}
+
+func (s *Server) writeAttachment(w http.ResponseWriter, filename string, data []byte) {
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+	w.Write(data)
+}
@@ -0,0 +1,64 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+const (
+	// scaleInterval is how often the worker pool is checked against demand
+	scaleInterval = time.Minute
+	// gcInterval is how often incomplete and orphaned worker resources are
+	// looked for
+	gcInterval = 10 * time.Minute
+)
+
+// autoscale keeps spare workers on top of the reserved ones. The pool is
+// checked periodically and whenever a worker is handed out or none is left.
+func (l *lab) autoscale(ctx context.Context) {
+	ticker := time.NewTicker(scaleInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-l.scale:
+		case <-ctx.Done():
+			return
+		}
+
+		// initial provisioning creates the workers first
+		if done, _ := l.provisioning.get(); !done {
+			continue
+		}
+		err := l.workerManager.Scale()
+		if err != nil {
+			l.log.Errorf("scaling workers: %v", err)
+		}
+	}
+}
+
+// requestScale asks autoscale for a pass without waiting for the interval
+func (l *lab) requestScale() {
+	select {
+	case l.scale <- struct{}{}:
+	default:
+	}
+}
+
+// collectGarbage periodically repairs incomplete workers and removes
+// resources left behind by removed ones
+func (l *lab) collectGarbage(ctx context.Context) {
+	wait.Until(func() {
+		// initial provisioning collects garbage itself
+		if done, _ := l.provisioning.get(); !done {
+			return
+		}
+		err := l.workerManager.GC()
+		if err != nil {
+			l.log.Errorf("collecting worker garbage: %v", err)
+		}
+	}, gcInterval, ctx.Done())
+}
@@ -1,243 +1,403 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
-	"fmt"
-	"html/template"
-	"log"
+	"net"
 	"net/http"
 	"strconv"
 	"sync"
+	"time"
 
 	"github.com/ghodss/yaml"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/sirupsen/logrus"
 
 	"github.com/mjudeikis/osa-labs/pkg/api"
-	"github.com/mjudeikis/osa-labs/pkg/store"
-	"github.com/mjudeikis/osa-labs/pkg/workers"
+	"github.com/mjudeikis/osa-labs/pkg/config"
+	"github.com/mjudeikis/osa-labs/pkg/inventory"
+	"github.com/mjudeikis/osa-labs/pkg/metrics"
+	"github.com/mjudeikis/osa-labs/pkg/templates"
 )
 
 var lock sync.Mutex
 
-type Server struct {
-	store         store.Store
-	log           *logrus.Entry
-	address       string
-	hostname      string
-	workerManager workers.Workers
-	devMode       bool
-}
+// shutdownTimeout bounds how long in-flight requests are drained on shutdown
+const shutdownTimeout = 30 * time.Second
 
-type setup struct {
-	hostname string
+type Server struct {
+	log        *logrus.Entry
+	config     *config.Config
+	address    string
+	hostname   string
+	devMode    bool
+	adminToken string
+	// trustedProxies may set X-Forwarded-For
+	trustedProxies []*net.IPNet
+	templates      *templates.Templates
+	labs           []*lab
+	labsByName     map[string]*lab
+	// registry holds the pool gauges of this server, the other metrics are
+	// global
+	registry *prometheus.Registry
+
+	// stopping is closed when the server starts shutting down
+	stopping chan struct{}
 }
 
-func New(log *logrus.Entry, devMode bool, hostname, address, workerImage string, workerNumber int) (*Server, error) {
-	store, err := store.New(log, "storage", "credentials")
+// New returns a server for a validated configuration
+func New(log *logrus.Entry, cfg *config.Config) (*Server, error) {
+	trustedProxies, err := config.ParseNetworks(cfg.TrustedProxies)
 	if err != nil {
 		return nil, err
 	}
 
-	wm, err := workers.New(log, workerImage, workerNumber)
+	server := &Server{
+		log:            log,
+		config:         cfg,
+		address:        cfg.Address,
+		hostname:       cfg.Hostname,
+		devMode:        cfg.DevMode,
+		adminToken:     cfg.AdminToken,
+		trustedProxies: trustedProxies,
+		templates:      templates.New(log, cfg.TemplateDir, cfg.DevMode),
+		labsByName:     map[string]*lab{},
+		registry:       prometheus.NewRegistry(),
+		stopping:       make(chan struct{}),
+	}
+	err = server.registry.Register(&poolCollector{s: server})
 	if err != nil {
 		return nil, err
 	}
 
-	server := &Server{
-		log:           log,
-		address:       address,
-		hostname:      hostname,
-		store:         store,
-		workerManager: wm,
-		devMode:       devMode,
+	for _, labConfig := range cfg.Labs {
+		l, err := newLab(log, cfg, labConfig)
+		if err != nil {
+			return nil, err
+		}
+		server.labs = append(server.labs, l)
+		server.labsByName[l.Name] = l
 	}
 	return server, nil
 }
 
-func (s *Server) Run() error {
-	if s.devMode {
-		s.dummyData()
-	}
+// Run serves until the context is done, then drains in-flight requests.
+// Workers are provisioned in the background.
+func (s *Server) Run(ctx context.Context) error {
+	var managers sync.WaitGroup
+	for _, l := range s.labs {
+		if s.devMode {
+			l.dummyData()
+		}
 
-	// init workers
-	err := s.workerManager.Create()
-	if err != nil {
-		return err
+		managers.Add(1)
+		go func(l *lab) {
+			defer managers.Done()
+			l.workerManager.Run(ctx)
+		}(l)
+		go l.provision(ctx)
+		go l.reconcileLeases(ctx)
+		go l.autoscale(ctx)
+		go l.collectGarbage(ctx)
 	}
 
-	http.HandleFunc("/", s.index)
-	http.HandleFunc("/setup", s.getSetup)
-	http.HandleFunc("/credentials", s.getCredentials)
-	http.HandleFunc("/worker", s.getWorker)
+	handlers := map[string]labHandler{
+		"/":            s.index,
+		"/setup":       s.getSetup,
+		"/credentials": s.getCredentials,
+		"/worker":      s.getWorker,
+		"/session":     s.getSession,
+		"/release":     s.release,
+
+		"/lab":             s.participantPage,
+		"/lab/key":         s.participantKey,
+		"/lab/ssh-config":  s.participantSSHConfig,
+		"/lab/terminal":    s.participantTerminal,
+		"/lab/terminal/ws": s.participantTerminalSocket,
+
+		"/admin/credentials":           s.admin(s.adminCredentials),
+		"/admin/credentials/reserve":   s.admin(s.adminReserveCredential),
+		"/admin/credentials/unreserve": s.admin(s.adminUnreserveCredential),
+		"/admin/credentials/annotate":  s.admin(s.adminAnnotateCredential),
+		"/admin/credentials/import":    s.admin(s.adminImportCredentials),
+		"/admin/export":                s.admin(s.adminExport),
+		"/admin/workers":               s.admin(s.adminWorkers),
+		"/admin/workers/reserve":       s.admin(s.adminReserveWorker),
+		"/admin/workers/unreserve":     s.admin(s.adminUnreserveWorker),
+		"/admin/workers/annotate":      s.admin(s.adminAnnotateWorker),
+		"/admin/workers/recycle":       s.admin(s.adminRecycleWorker),
+	}
 
-	log.Printf("Listening on %s", s.address)
-	return http.ListenAndServe(s.address, nil)
-}
+	for path, h := range handlers {
+		handlers[path] = s.instrument(path, h)
+	}
 
-func (s *Server) getCredentials(w http.ResponseWriter, r *http.Request) {
-	s.log.Debug("getCredentials")
+	mux := http.NewServeMux()
+	// the first lab is served at the root, all of them under /labs/{name}
+	for path, h := range handlers {
+		mux.HandleFunc(path, s.withLab(s.labs[0], h))
+	}
+	mux.HandleFunc("/labs/", s.routeLabs(handlers))
+	mux.Handle("/static/", http.StripPrefix("/static/", s.templates.Static()))
+	mux.Handle("/metrics", promhttp.HandlerFor(prometheus.Gatherers{prometheus.DefaultGatherer, s.registry}, promhttp.HandlerOpts{}))
+	mux.HandleFunc("/healthz", s.healthz)
+	mux.HandleFunc("/readyz", s.readyz)
+
+	server := &http.Server{
+		Addr:    s.address,
+		Handler: mux,
+	}
 
-	result, err := s.getUniqueCredential()
-	if err != nil {
-		s.log.Error(err)
-		resp := fmt.Sprintf("500 Internal Error: %s", err)
-		http.Error(w, resp, http.StatusInternalServerError)
+	errCh := make(chan error, 2)
+	go func() {
+		s.log.Infof("Listening on %s", s.address)
+		errCh <- server.ListenAndServe()
+	}()
+	if s.config.Workers.Exposure.Type == config.ExposureGateway {
+		go func() {
+			errCh <- s.runGateway(ctx)
+		}()
 	}
 
-	var res []byte
-	res, err = json.Marshal(result)
-	if err != nil {
-		s.log.Error(err)
-		resp := fmt.Sprintf("500 Internal Error: %s", err)
-		http.Error(w, resp, http.StatusInternalServerError)
-		return
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
 	}
-	w.Write(res)
+
+	s.log.Info("shutting down")
+	// wakes up long-polling participants so they are not held until the
+	// timeout
+	close(s.stopping)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	err := server.Shutdown(shutdownCtx)
+	// the worker backends clean up once the context is done, e.g. local
+	// workers are stopped, which must not be cut short by exiting
+	managers.Wait()
+	return err
 }
 
-func (s *Server) getWorker(w http.ResponseWriter, r *http.Request) {
-	s.log.Debug("getWorker")
+func (s *Server) getCredentials(w http.ResponseWriter, r *http.Request, l *lab) {
+	l.log.Debug("getCredentials")
 
-	result, err := s.getUniqueWorker()
+	claim, err := s.participant(w, r)
 	if err != nil {
-		s.log.Error(err)
-		resp := fmt.Sprintf("500 Internal Error: %s", err)
-		http.Error(w, resp, http.StatusInternalServerError)
-	}
-
-	var res []byte
-	res, err = json.Marshal(result)
-	if err != nil {
-		s.log.Error(err)
-		resp := fmt.Sprintf("500 Internal Error: %s", err)
-		http.Error(w, resp, http.StatusInternalServerError)
+		s.writeError(w, err)
 		return
 	}
-	w.Write(res)
+
+	s.reserveOrWait(w, r, l, claim, []string{poolCredentials}, func(ahead turn) (interface{}, error) {
+		return l.getUniqueCredential(claim, s.holder(r), ahead[poolCredentials])
+	})
 }
 
-func (s *Server) getSetup(w http.ResponseWriter, r *http.Request) {
-	s.log.Debug("getSetup")
-	t, err := template.New("setup.sh").ParseFiles("template/setup.sh")
+func (s *Server) getWorker(w http.ResponseWriter, r *http.Request, l *lab) {
+	l.log.Debug("getWorker")
+
+	claim, err := s.participant(w, r)
 	if err != nil {
-		s.log.Error(err)
-		resp := fmt.Sprintf("500 Internal Error: %s", err)
-		http.Error(w, resp, http.StatusInternalServerError)
+		s.writeError(w, err)
 		return
 	}
 
-	tmpl := struct {
-		Hostname string
-	}{
-		Hostname: s.hostname,
-	}
+	s.reserveOrWait(w, r, l, claim, []string{poolWorkers}, func(ahead turn) (interface{}, error) {
+		return l.getUniqueWorker(claim, s.holder(r), ahead[poolWorkers])
+	})
+}
 
-	err = t.Execute(w, tmpl)
-	if err != nil {
-		s.log.Error(err)
-		resp := fmt.Sprintf("500 Internal Error: %s", err)
-		http.Error(w, resp, http.StatusInternalServerError)
-		return
+func (s *Server) index(w http.ResponseWriter, r *http.Request, l *lab) {
+	l.log.Debug("index")
+
+	data := s.templateData(l)
+	if len(s.labs) > 1 {
+		for _, other := range s.labs {
+			data.Labs = append(data.Labs, labLink{
+				Name:  other.Name,
+				Title: other.Title,
+				URL:   s.hostname + "/labs/" + other.Name + "/",
+			})
+		}
 	}
 
+	s.writeTemplate(w, http.StatusOK, "index.html", data)
 }
 
-func (s *Server) index(w http.ResponseWriter, r *http.Request) {
-	s.log.Debug("index")
-	t, err := template.New("index.html").ParseFiles("template/index.html")
+func (l *lab) getUniqueCredential(claim, holder string, ahead int) (*api.Credential, error) {
+	lock.Lock()
+	defer lock.Unlock()
+	credentialStore, err := l.loadCredentials()
 	if err != nil {
-		s.log.Error(err)
-		resp := fmt.Sprintf("500 Internal Error: %s", err)
-		http.Error(w, resp, http.StatusInternalServerError)
-		return
+		return nil, err
 	}
 
-	tmpl := struct {
-		Hostname string
-	}{
-		Hostname: s.hostname,
+	lease := l.newLease(claim, holder)
+	result := reserveCredential(credentialStore, lease, ahead)
+	if result == nil {
+		return nil, errCredentialsExhausted
+	}
+	if result.Lease != lease {
+		return result, nil
 	}
 
-	err = t.Execute(w, tmpl)
+	err = l.saveCredentials(credentialStore)
 	if err != nil {
-		s.log.Error(err)
-		resp := fmt.Sprintf("500 Internal Error: %s", err)
-		http.Error(w, resp, http.StatusInternalServerError)
-		return
+		return nil, err
 	}
+	metrics.Handouts.WithLabelValues(l.Name, "credentials").Inc()
+	err = l.recordAssignment(api.Assignment{
+		Claim:      claim,
+		Holder:     holder,
+		Username:   result.Username,
+		AssignedAt: lease.ReservedAt,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
 }
 
-func (s *Server) getUniqueCredential() (*api.Credential, error) {
+func (l *lab) getUniqueWorker(claim, holder string, ahead int) (*api.Worker, error) {
 	lock.Lock()
 	defer lock.Unlock()
-	data, err := s.store.Get("credentials")
+
+	lease := l.newLease(claim, holder)
+	result, err := l.workerManager.Get(lease, ahead)
 	if err != nil {
 		return nil, err
 	}
-	var result *api.Credential
-	var credentialStore api.CredentialsStore
-
-	err = yaml.Unmarshal(data, &credentialStore)
+	if result == nil {
+		l.requestScale()
+		return nil, errWorkersExhausted
+	}
+	if result.Lease != lease {
+		return result, nil
+	}
+	l.requestScale()
+
+	metrics.Handouts.WithLabelValues(l.Name, "workers").Inc()
+	err = l.recordAssignment(api.Assignment{
+		Claim:      claim,
+		Holder:     holder,
+		Worker:     result.Name,
+		AssignedAt: lease.ReservedAt,
+	})
 	if err != nil {
 		return nil, err
 	}
+	return result, nil
+}
 
+// reserveCredential returns the credential already leased to the claim or
+// reserves the first free one, leaving enough free credentials for the ahead
+// participants queued in front. It returns nil if the pool is exhausted.
+func reserveCredential(credentialStore *api.CredentialsStore, lease *api.Lease, ahead int) *api.Credential {
+	// repeated request from the same participant gets the same credential
 	for key, cred := range credentialStore.Credentials {
-		if !cred.Reserved {
-			credentialStore.Credentials[key].Reserved = true
-			result = &cred
-			break
+		if cred.Reserved && cred.Lease != nil && cred.Lease.Claim == lease.Claim {
+			return &credentialStore.Credentials[key]
 		}
 	}
 
-	data, err = yaml.Marshal(credentialStore)
-	if err != nil {
-		return nil, err
+	for key, cred := range credentialStore.Credentials {
+		if cred.Reserved {
+			continue
+		}
+		if ahead > 0 {
+			ahead--
+			continue
+		}
+		credentialStore.Credentials[key].Reserved = true
+		credentialStore.Credentials[key].Lease = lease
+		return &credentialStore.Credentials[key]
 	}
+	return nil
+}
 
-	err = s.store.Put("credentials", data)
+// updateCredential applies fn to the credential with the given username and
+// saves the result. It returns false if there is no such credential.
+func (l *lab) updateCredential(username string, fn func(*api.Credential)) (bool, error) {
+	lock.Lock()
+	defer lock.Unlock()
+	credentialStore, err := l.loadCredentials()
 	if err != nil {
-		return nil, err
+		return false, err
 	}
-	return result, nil
+
+	for key, cred := range credentialStore.Credentials {
+		if cred.Username == username {
+			fn(&credentialStore.Credentials[key])
+			err = l.saveCredentials(credentialStore)
+			if err != nil {
+				return true, err
+			}
+			return true, l.trackReservation(cred.Reserved, credentialStore.Credentials[key].Reserved, credentialStore.Credentials[key].Lease, username, "")
+		}
+	}
+	return false, nil
 }
 
-func (s *Server) getUniqueWorker() (*api.Worker, error) {
+// updateWorker applies fn to the worker with the given name through the
+// worker backend. It returns false if there is no such worker.
+func (l *lab) updateWorker(name string, fn func(*api.Worker)) (bool, error) {
 	lock.Lock()
 	defer lock.Unlock()
-	data, err := s.store.Get("workers")
-	if err != nil {
-		return nil, err
+
+	var wasReserved bool
+	wk, err := l.workerManager.Update(name, func(wk *api.Worker) {
+		wasReserved = wk.Reserved
+		fn(wk)
+	})
+	if err != nil || wk == nil {
+		return wk != nil, err
 	}
-	var result *api.Worker
-	var workerStore api.WorkersStore
+	return true, l.trackReservation(wasReserved, wk.Reserved, wk.Lease, "", name)
+}
+
+func (l *lab) loadCredentials() (*api.CredentialsStore, error) {
+	return inventory.LoadCredentials(l.store)
+}
 
-	err = yaml.Unmarshal(data, &workerStore)
+func (l *lab) saveCredentials(credentialStore *api.CredentialsStore) error {
+	return inventory.SaveCredentials(l.store, credentialStore)
+}
+
+// loadWorkers lists the workers known to the worker backend
+func (l *lab) loadWorkers() (*api.WorkersStore, error) {
+	workers, err := l.workerManager.List()
 	if err != nil {
 		return nil, err
 	}
+	return &api.WorkersStore{Workers: workers}, nil
+}
 
-	for key, wk := range workerStore.Workers {
-		if !wk.Reserved {
-			workerStore.Workers[key].Reserved = true
-			result = &wk
-			break
-		}
-	}
+// loadReport reports the workers of the worker backend with the credentials
+// and assignments of the store
+func (l *lab) loadReport(now time.Time) (*inventory.Report, error) {
+	lock.Lock()
+	defer lock.Unlock()
 
-	data, err = yaml.Marshal(workerStore)
+	workerStore, err := l.loadWorkers()
 	if err != nil {
 		return nil, err
 	}
+	return inventory.LoadReport(l.store, workerStore, now)
+}
 
-	err = s.store.Put("workers", data)
+func (s *Server) writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	res, err := json.Marshal(v)
 	if err != nil {
-		return nil, err
+		s.writeError(w, err)
+		return
 	}
-	return result, nil
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(res)
 }
 
-func (s *Server) dummyData() {
+func (l *lab) dummyData() {
 	// dummy code to produce credentials file
 	var cs api.CredentialsStore
 	for i := 1; i <= 50; i++ {
@@ -252,22 +412,5 @@ func (s *Server) dummyData() {
 	if err != nil {
 		panic(err)
 	}
-	s.store.Put("credentials", bytes)
-
-	// dummy code to produce credentials file
-	var wk api.WorkersStore
-	for i := 1; i <= 50; i++ {
-		wk.Workers = append(wk.Workers, api.Worker{
-			IP:       "1.1.1.1",
-			SSHKey:   "dummy ssh key",
-			Reserved: false,
-		})
-	}
-
-	bytes, err = yaml.Marshal(wk)
-	if err != nil {
-		panic(err)
-	}
-	s.store.Put("workers", bytes)
-
+	l.store.Put("credentials", bytes)
 }
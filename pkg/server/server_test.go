@@ -0,0 +1,174 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/mjudeikis/osa-labs/pkg/api"
+	"github.com/mjudeikis/osa-labs/pkg/config"
+	"github.com/mjudeikis/osa-labs/pkg/inventory"
+	"github.com/mjudeikis/osa-labs/pkg/store"
+	"github.com/mjudeikis/osa-labs/pkg/templates"
+	"github.com/mjudeikis/osa-labs/pkg/workers"
+)
+
+// newTestServer returns a server with a single lab handing out the
+// credentials and the workers of wm
+func newTestServer(t *testing.T, wm workers.Workers, credentials ...api.Credential) (*Server, *lab) {
+	t.Helper()
+	log := logrus.NewEntry(logrus.StandardLogger())
+	cfg := config.Default()
+	cfg.StorageDir = t.TempDir()
+	err := cfg.Validate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	storage, err := store.New(log, cfg.StorageDir, cfg.Labs[0].StoreNamespace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := &lab{
+		Lab:           cfg.Labs[0],
+		log:           log,
+		store:         storage,
+		workerManager: wm,
+		waitlist:      newWaitlist(),
+		leaseTTL:      cfg.LeaseTTL.Duration,
+		scale:         make(chan struct{}, 1),
+	}
+	err = l.saveCredentials(&api.CredentialsStore{Credentials: credentials})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &Server{
+		log:        log,
+		config:     cfg,
+		address:    cfg.Address,
+		hostname:   cfg.Hostname,
+		templates:  templates.New(log, "", false),
+		labs:       []*lab{l},
+		labsByName: map[string]*lab{l.Name: l},
+		stopping:   make(chan struct{}),
+	}
+	return s, l
+}
+
+// serve calls h with a request carrying the form values and returns the
+// recorded response
+func serve(s *Server, l *lab, h labHandler, method, path string, values url.Values) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, strings.NewReader(values.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	h(w, r, l)
+	return w
+}
+
+func decode(t *testing.T, w *httptest.ResponseRecorder, v interface{}) {
+	t.Helper()
+	err := json.Unmarshal(w.Body.Bytes(), v)
+	if err != nil {
+		t.Fatalf("decoding %q: %v", w.Body.String(), err)
+	}
+}
+
+func TestGetWorker(t *testing.T) {
+	wm := workers.NewMemory(api.Worker{Name: "a", Host: "1.2.3.4", Port: 2222})
+	s, l := newTestServer(t, wm)
+
+	w := serve(s, l, s.getWorker, http.MethodGet, "/worker?claim=c1", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var wk api.Worker
+	decode(t, w, &wk)
+	if wk.Name != "a" || !wk.Reserved || wk.Lease.Claim != "c1" {
+		t.Errorf("unexpected worker %#v", wk)
+	}
+
+	// the same participant gets the same worker, without a new handout
+	w = serve(s, l, s.getWorker, http.MethodGet, "/worker?claim=c1", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	assignments, err := l.loadAssignments()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(assignments.Assignments) != 1 {
+		t.Errorf("expected one assignment, got %d", len(assignments.Assignments))
+	}
+
+	w = serve(s, l, s.getWorker, http.MethodGet, "/worker?claim=c2", nil)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("expected 202, got %d: %s", w.Code, w.Body.String())
+	}
+	var waiting api.Waiting
+	decode(t, w, &waiting)
+	if waiting.Position != 1 || waiting.Reason != errWorkersExhausted.Code {
+		t.Errorf("unexpected waiting %#v", waiting)
+	}
+}
+
+func TestAdminExportWorkers(t *testing.T) {
+	wm := workers.NewMemory(api.Worker{Name: "a"}, api.Worker{Name: "b"})
+	s, l := newTestServer(t, wm)
+	_, err := l.getUniqueWorker("c1", "test", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the worker backend, not a store snapshot, knows the reservation
+	w := serve(s, l, s.adminExport, http.MethodGet, "/admin/export", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var report inventory.Report
+	decode(t, w, &report)
+	if report.WorkersSummary != (inventory.Summary{Total: 2, Reserved: 1, Free: 1}) {
+		t.Errorf("unexpected workers summary %#v", report.WorkersSummary)
+	}
+}
+
+func TestNewRegistersMetrics(t *testing.T) {
+	log := logrus.NewEntry(logrus.StandardLogger())
+	cfg := config.Default()
+	cfg.StorageDir = t.TempDir()
+	cfg.Workers.Backend = config.BackendLocal
+	err := cfg.Validate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// every server registers its pool gauges with a registry of its own
+	for i := 0; i < 2; i++ {
+		s, err := New(log, cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		families, err := s.registry.Gather()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(families) != 1 || families[0].GetName() != "osa_labs_pool_entries" {
+			t.Errorf("unexpected metrics %v", families)
+		}
+	}
+}
+
+func TestWriteErrorUnsupported(t *testing.T) {
+	s, _ := newTestServer(t, workers.NewMemory())
+
+	w := httptest.NewRecorder()
+	s.writeError(w, workers.ErrUnsupported)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400, got %d", w.Code)
+	}
+}
@@ -0,0 +1,180 @@
+package server
+
+import (
+	"net/http"
+	"os"
+
+	"github.com/mjudeikis/osa-labs/pkg/api"
+	"github.com/mjudeikis/osa-labs/pkg/metrics"
+)
+
+// sessionPools are the pools a session is handed out from
+var sessionPools = []string{poolCredentials, poolWorkers}
+
+// getSession hands out a credential and a worker to the participant in one
+// request
+func (s *Server) getSession(w http.ResponseWriter, r *http.Request, l *lab) {
+	l.log.Debug("getSession")
+
+	claim, err := s.participant(w, r)
+	if err != nil {
+		s.writeError(w, err)
+		return
+	}
+
+	s.reserveOrWait(w, r, l, claim, sessionPools, func(ahead turn) (interface{}, error) {
+		return l.reserveSession(claim, s.holder(r), ahead)
+	})
+}
+
+// reserveSession reserves a credential and a worker for the claim under a
+// single lock. Either both are reserved and recorded as an assignment, or the
+// store and worker backend are left as they were. It fails if either pool is
+// exhausted. Free entries of each pool are left for the participants queued
+// in front for it.
+func (l *lab) reserveSession(claim, holder string, ahead turn) (*api.Session, error) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	credentialStore, err := l.loadCredentials()
+	if err != nil {
+		return nil, err
+	}
+	assignmentStore, err := l.loadAssignments()
+	if err != nil {
+		return nil, err
+	}
+
+	lease := l.newLease(claim, holder)
+	cred := reserveCredential(credentialStore, lease, ahead[poolCredentials])
+	if cred == nil {
+		return nil, errCredentialsExhausted
+	}
+	wk, err := l.workerManager.Get(lease, ahead[poolWorkers])
+	if err != nil {
+		return nil, err
+	}
+	if wk == nil {
+		l.requestScale()
+		return nil, errWorkersExhausted
+	}
+	if wk.Lease == lease {
+		l.requestScale()
+	}
+
+	addAssignment(assignmentStore, api.Assignment{
+		Claim:      claim,
+		Holder:     holder,
+		Username:   cred.Username,
+		Worker:     wk.Name,
+		AssignedAt: lease.ReservedAt,
+	})
+
+	err = l.commit([]record{
+		{key: "credentials", save: func() error { return l.saveCredentials(credentialStore) }},
+		{key: "assignments", save: func() error { return l.saveAssignments(assignmentStore) }},
+	})
+	if err != nil {
+		if wk.Lease == lease {
+			l.unreserveWorker(wk.Name, lease)
+		}
+		return nil, err
+	}
+	if cred.Lease == lease {
+		metrics.Handouts.WithLabelValues(l.Name, "credentials").Inc()
+	}
+	if wk.Lease == lease {
+		metrics.Handouts.WithLabelValues(l.Name, "workers").Inc()
+	}
+
+	return &api.Session{
+		Claim:      claim,
+		Credential: cred,
+		Worker:     wk,
+	}, nil
+}
+
+// unreserveWorker returns a worker reserved by a session that failed to be
+// recorded, unless it has been leased again since
+func (l *lab) unreserveWorker(name string, lease *api.Lease) {
+	_, err := l.workerManager.Update(name, func(wk *api.Worker) {
+		if wk.Lease != nil && wk.Lease.Claim == lease.Claim && wk.Lease.ReservedAt.Equal(lease.ReservedAt) {
+			wk.Reserved = false
+			wk.Lease = nil
+		}
+	})
+	if err != nil {
+		l.log.Errorf("failed to roll back worker %s: %v", name, err)
+	}
+}
+
+// findSession returns the credential and worker currently held by the claim,
+// or nil if it holds neither
+func (l *lab) findSession(claim string) (*api.Session, error) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	credentialStore, err := l.loadCredentials()
+	if err != nil {
+		return nil, err
+	}
+	workerStore, err := l.loadWorkers()
+	if err != nil {
+		return nil, err
+	}
+
+	session := &api.Session{Claim: claim}
+	for i := range credentialStore.Credentials {
+		cred := &credentialStore.Credentials[i]
+		if cred.Reserved && cred.Lease != nil && cred.Lease.Claim == claim {
+			session.Credential = cred
+			break
+		}
+	}
+	for i := range workerStore.Workers {
+		wk := &workerStore.Workers[i]
+		if wk.Reserved && wk.Lease != nil && wk.Lease.Claim == claim {
+			session.Worker = wk
+			break
+		}
+	}
+	if session.Credential == nil && session.Worker == nil {
+		return nil, nil
+	}
+	return session, nil
+}
+
+type record struct {
+	key  string
+	save func() error
+}
+
+// commit saves the records in order. If a save fails, records saved before it
+// are restored to their previous content.
+func (l *lab) commit(records []record) error {
+	originals := map[string][]byte{}
+	for _, rec := range records {
+		data, err := l.store.Get(rec.key)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		originals[rec.key] = data
+	}
+
+	for i, rec := range records {
+		err := rec.save()
+		if err == nil {
+			continue
+		}
+		for _, done := range records[:i] {
+			if originals[done.key] == nil {
+				continue
+			}
+			if rerr := l.store.Put(done.key, originals[done.key]); rerr != nil {
+				l.log.Errorf("failed to roll back %s: %v", done.key, rerr)
+			}
+		}
+		return err
+	}
+	return nil
+}
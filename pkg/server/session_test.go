@@ -0,0 +1,147 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/mjudeikis/osa-labs/pkg/api"
+	"github.com/mjudeikis/osa-labs/pkg/workers"
+)
+
+func TestSession(t *testing.T) {
+	wm := workers.NewMemory(api.Worker{Name: "a", Host: "1.2.3.4", Port: 2222})
+	s, l := newTestServer(t, wm,
+		api.Credential{Username: "u1", Password: "p1"},
+		api.Credential{Username: "u2", Password: "p2"},
+	)
+
+	w := serve(s, l, s.getSession, http.MethodGet, "/session?claim=c1", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var session api.Session
+	decode(t, w, &session)
+	if session.Credential.Username != "u1" || session.Worker.Name != "a" {
+		t.Fatalf("unexpected session %#v", session)
+	}
+
+	// the workers are exhausted, so the second participant keeps no
+	// credential either
+	w = serve(s, l, s.getSession, http.MethodGet, "/session?claim=c2", nil)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("expected 202, got %d: %s", w.Code, w.Body.String())
+	}
+	credentialStore, err := l.loadCredentials()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if credentialStore.Credentials[1].Reserved {
+		t.Error("expected the credential of the waiting participant to be free")
+	}
+
+	// only the participant holding the lease can release it
+	w = serve(s, l, s.release, http.MethodPost, "/release?claim=c2", url.Values{"worker": {"a"}})
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("expected 403, got %d: %s", w.Code, w.Body.String())
+	}
+	w = serve(s, l, s.release, http.MethodPost, "/release?claim=c1", url.Values{"worker": {"a"}})
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d: %s", w.Code, w.Body.String())
+	}
+	if wm.Recycled("a") != 1 {
+		t.Error("expected the released worker to be recycled")
+	}
+
+	w = serve(s, l, s.getSession, http.MethodGet, "/session?claim=c2", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	decode(t, w, &session)
+	if session.Credential.Username != "u2" || session.Worker.Name != "a" {
+		t.Errorf("unexpected session %#v", session)
+	}
+
+	assignments, err := l.loadAssignments()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// c1 still holds its credential
+	if len(assignments.Assignments) != 3 ||
+		assignments.Assignments[0].Worker != "a" || assignments.Assignments[0].ReleasedAt == nil ||
+		assignments.Assignments[1].Username != "u1" || assignments.Assignments[1].ReleasedAt != nil {
+		t.Errorf("expected only the worker of the first assignment to be returned, got %#v", assignments.Assignments)
+	}
+}
+
+func TestAdminRecycleWorker(t *testing.T) {
+	wm := workers.NewMemory(api.Worker{Name: "a"})
+	s, l := newTestServer(t, wm)
+
+	w := serve(s, l, s.adminRecycleWorker, http.MethodPost, "/admin/workers/recycle", url.Values{"name": {"a"}})
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("expected 202, got %d: %s", w.Code, w.Body.String())
+	}
+	if wm.Recycled("a") != 1 {
+		t.Error("expected the worker to be recycled")
+	}
+
+	w = serve(s, l, s.adminRecycleWorker, http.MethodPost, "/admin/workers/recycle", url.Values{"name": {"missing"}})
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected 404, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestRemoveReservedCredential(t *testing.T) {
+	s, l := newTestServer(t, workers.NewMemory(), api.Credential{Username: "u1", Password: "p1"})
+	_, err := l.getUniqueCredential("c1", "test", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := serve(s, l, s.removeCredential, http.MethodDelete, "/admin/credentials?username=u1", nil)
+	if w.Code != http.StatusConflict {
+		t.Fatalf("expected 409, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w = serve(s, l, s.adminUnreserveCredential, http.MethodPost, "/admin/credentials/unreserve", url.Values{"username": {"u1"}})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	w = serve(s, l, s.removeCredential, http.MethodDelete, "/admin/credentials?username=u1", nil)
+	if w.Code != http.StatusNoContent {
+		t.Errorf("expected 204, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestReleaseRecycles(t *testing.T) {
+	wm := workers.NewMemory(api.Worker{Name: "a"})
+	s, l := newTestServer(t, wm)
+	s.adminToken = "secret"
+	_, err := l.getUniqueWorker("c1", "test", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, claim := range []string{"", "c2"} {
+		w := serve(s, l, s.release, http.MethodPost, "/release?claim="+claim, url.Values{"worker": {"a"}})
+		if w.Code != http.StatusForbidden {
+			t.Errorf("expected 403 for claim %q, got %d: %s", claim, w.Code, w.Body.String())
+		}
+	}
+	if wm.Recycled("a") != 0 {
+		t.Fatal("expected the worker not to be recycled by another participant")
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/release?worker=a", nil)
+	r.Header.Set("Authorization", "Bearer secret")
+	w := httptest.NewRecorder()
+	s.release(w, r, l)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d: %s", w.Code, w.Body.String())
+	}
+	if wm.Recycled("a") != 1 {
+		t.Error("expected the worker released by the admin to be recycled")
+	}
+}
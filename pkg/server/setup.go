@@ -0,0 +1,125 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/mjudeikis/osa-labs/pkg/api"
+)
+
+// setup script flavours
+const (
+	flavourSh         = "sh"
+	flavourPowerShell = "ps1"
+	flavourText       = "txt"
+	flavourJSON       = "json"
+)
+
+// setupFlavour picks the setup script flavour from the flavour query parameter,
+// falling back to the User-Agent and Accept headers
+func setupFlavour(r *http.Request) (string, error) {
+	switch flavour := r.URL.Query().Get("flavour"); flavour {
+	case flavourSh, flavourPowerShell, flavourText, flavourJSON:
+		return flavour, nil
+	case "":
+	default:
+		return "", badRequest("unsupported flavour %q, expected sh, ps1, txt or json", flavour)
+	}
+
+	switch {
+	case strings.Contains(r.UserAgent(), "PowerShell"):
+		return flavourPowerShell, nil
+	case strings.HasPrefix(r.Header.Get("Accept"), "application/json"):
+		return flavourJSON, nil
+	}
+	return flavourSh, nil
+}
+
+// getSetup serves the setup script. Scripts are served in two stages: the
+// first one persists the participant's claim and polls for the session stage,
+// which is rendered with the reserved credential and worker. The txt and json
+// flavours reserve the session straight away.
+func (s *Server) getSetup(w http.ResponseWriter, r *http.Request, l *lab) {
+	l.log.Debug("getSetup")
+
+	flavour, err := setupFlavour(r)
+	if err != nil {
+		s.writeError(w, err)
+		return
+	}
+
+	switch {
+	case flavour == flavourJSON:
+		s.getSession(w, r, l)
+	case flavour == flavourText || r.URL.Query().Get("stage") == "session":
+		s.setupSession(w, r, l, flavour)
+	default:
+		data := s.templateData(l)
+		data.Claim = claimID(r)
+		if data.Claim == "" {
+			data.Claim, err = newClaimID()
+			if err != nil {
+				s.writeError(w, err)
+				return
+			}
+		}
+
+		name := l.SetupTemplate
+		if flavour == flavourPowerShell {
+			name = "setup.ps1"
+		}
+		s.writeTemplate(w, http.StatusOK, name, data)
+	}
+}
+
+// setupSession reserves a session and renders it in the requested flavour
+func (s *Server) setupSession(w http.ResponseWriter, r *http.Request, l *lab, flavour string) {
+	claim, err := s.participant(w, r)
+	if err != nil {
+		s.writeError(w, err)
+		return
+	}
+
+	result, waiting, err := s.waitForTurn(r, l, claim, sessionPools, func(ahead turn) (interface{}, error) {
+		return l.reserveSession(claim, s.holder(r), ahead)
+	})
+
+	// the first stage scripts parse the waitlist position and errors as JSON
+	switch {
+	case err != nil:
+		s.writeError(w, err)
+	case waiting != nil && flavour == flavourText:
+		s.writeText(w, http.StatusAccepted, fmt.Sprintf("All lab environments are taken right now. You are number %d in the queue.\nTry again with: curl -sk -H '%s: %s' '%s'\n", waiting.Position, claimHeader, claim, s.labURL(l)+"/setup?flavour=txt&wait=30s"))
+	case waiting != nil:
+		s.writeJSON(w, http.StatusAccepted, waiting)
+	case result != nil:
+		data := s.templateData(l)
+		data.Claim = claim
+		data.setSession(result.(*api.Session))
+		s.writeTemplate(w, http.StatusOK, "session."+flavour, data)
+	}
+}
+
+// writeTemplate renders the template fully before writing it, so a failing
+// template results in an error response instead of a truncated script
+func (s *Server) writeTemplate(w http.ResponseWriter, status int, name string, data interface{}) {
+	var buf bytes.Buffer
+	err := s.templates.Execute(&buf, name, data)
+	if err != nil {
+		s.writeError(w, err)
+		return
+	}
+	if !strings.HasSuffix(name, ".html") {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	}
+	w.WriteHeader(status)
+	w.Write(buf.Bytes())
+}
+
+func (s *Server) writeText(w http.ResponseWriter, status int, text string) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(status)
+	w.Write([]byte(text))
+}
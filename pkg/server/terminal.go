@@ -0,0 +1,190 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/net/websocket"
+
+	"github.com/mjudeikis/osa-labs/pkg/api"
+)
+
+// terminalDialTimeout bounds connecting to the worker
+const terminalDialTimeout = 10 * time.Second
+
+// terminalMessage is sent by the terminal page over the WebSocket
+type terminalMessage struct {
+	Type string `json:"type"`
+	Data string `json:"data,omitempty"`
+	Cols int    `json:"cols,omitempty"`
+	Rows int    `json:"rows,omitempty"`
+}
+
+// participantTerminal serves the web terminal page for the claim's worker
+func (s *Server) participantTerminal(w http.ResponseWriter, r *http.Request, l *lab) {
+	l.log.Debug("participantTerminal")
+
+	session, err := s.participantSession(r, l)
+	if err != nil {
+		s.writeError(w, err)
+		return
+	}
+
+	data := s.templateData(l)
+	data.Claim = session.Claim
+	data.setSession(session)
+	s.writeTemplate(w, http.StatusOK, "terminal.html", data)
+}
+
+// participantTerminalSocket connects the terminal page to an SSH session on
+// the claim's worker. Workstations often block the worker port, so the
+// dispatcher makes the SSH connection on their behalf.
+func (s *Server) participantTerminalSocket(w http.ResponseWriter, r *http.Request, l *lab) {
+	l.log.Debug("participantTerminalSocket")
+
+	session, err := s.participantSession(r, l)
+	if err != nil {
+		s.writeError(w, err)
+		return
+	}
+
+	websocket.Server{
+		Handshake: s.checkOrigin,
+		Handler: func(ws *websocket.Conn) {
+			defer ws.Close()
+			err := s.terminal(ws, session.Worker)
+			if err != nil {
+				l.log.Warnf("terminal for claim %s: %v", session.Claim, err)
+				websocket.Message.Send(ws, []byte("\r\n"+err.Error()+"\r\n"))
+			}
+		},
+	}.ServeHTTP(w, r)
+}
+
+// checkOrigin only accepts WebSockets opened by the pages of the dispatcher.
+// The claim may come from a cookie, so any other site could otherwise open a
+// shell on the participant's worker.
+func (s *Server) checkOrigin(config *websocket.Config, r *http.Request) error {
+	origin, err := websocket.Origin(config, r)
+	if err != nil {
+		return err
+	}
+	hostname, err := url.Parse(s.hostname)
+	if err != nil {
+		return err
+	}
+	if origin == nil || !strings.EqualFold(origin.Scheme, hostname.Scheme) || !strings.EqualFold(origin.Host, hostname.Host) {
+		return fmt.Errorf("origin %q is not allowed", r.Header.Get("Origin"))
+	}
+	config.Origin = origin
+	return nil
+}
+
+func (s *Server) terminal(ws *websocket.Conn, worker *api.Worker) error {
+	signer, err := ssh.ParsePrivateKey([]byte(worker.SSHKey))
+	if err != nil {
+		return err
+	}
+
+	// the frontend reaches the worker directly, not through its exposure
+	address := worker.Address
+	if address == "" {
+		address = net.JoinHostPort(worker.Host, strconv.Itoa(int(worker.Port)))
+	}
+	client, err := ssh.Dial("tcp", address, &ssh.ClientConfig{
+		User: worker.User,
+		Auth: []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		// worker host keys are generated on start and not known to us
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		Timeout:         terminalDialTimeout,
+	})
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	session, err := client.NewSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	// the page sends its size first
+	var size terminalMessage
+	err = websocket.JSON.Receive(ws, &size)
+	if err != nil {
+		return err
+	}
+	if size.Cols <= 0 || size.Rows <= 0 {
+		size.Cols, size.Rows = 80, 24
+	}
+
+	err = session.RequestPty("xterm-256color", size.Rows, size.Cols, ssh.TerminalModes{ssh.ECHO: 1})
+	if err != nil {
+		return err
+	}
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	err = session.Shell()
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		buf := make([]byte, 32*1024)
+		for {
+			n, err := stdout.Read(buf)
+			if n > 0 {
+				if websocket.Message.Send(ws, buf[:n]) != nil {
+					break
+				}
+			}
+			if err != nil {
+				break
+			}
+		}
+		// closing the socket ends the receive loop below
+		ws.Close()
+	}()
+
+	for {
+		var raw string
+		err := websocket.Message.Receive(ws, &raw)
+		if err != nil {
+			// the page went away, or the shell exited and the socket was
+			// closed above
+			return nil
+		}
+
+		var msg terminalMessage
+		err = json.Unmarshal([]byte(raw), &msg)
+		if err != nil {
+			return err
+		}
+		switch msg.Type {
+		case "input":
+			_, err = io.WriteString(stdin, msg.Data)
+		case "resize":
+			if msg.Cols > 0 && msg.Rows > 0 {
+				err = session.WindowChange(msg.Rows, msg.Cols)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
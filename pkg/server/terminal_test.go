@@ -0,0 +1,44 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/websocket"
+
+	"github.com/mjudeikis/osa-labs/pkg/api"
+	"github.com/mjudeikis/osa-labs/pkg/workers"
+)
+
+func TestTerminalOrigin(t *testing.T) {
+	wm := workers.NewMemory(api.Worker{Name: "a", Address: "127.0.0.1:1", User: "lab"})
+	s, l := newTestServer(t, wm)
+	_, err := l.getUniqueWorker("c1", "test", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.participantTerminalSocket(w, r, l)
+	}))
+	defer ts.Close()
+	socketURL := "ws" + strings.TrimPrefix(ts.URL, "http") + "/lab/terminal/ws?claim=c1"
+
+	for _, tt := range []struct {
+		origin  string
+		wantErr bool
+	}{
+		{origin: s.hostname},
+		{origin: "https://attacker.example.com", wantErr: true},
+		{origin: "http://localhost:8081", wantErr: true},
+	} {
+		ws, err := websocket.Dial(socketURL, "", tt.origin)
+		if tt.wantErr != (err != nil) {
+			t.Errorf("origin %s: expected error %v, got %v", tt.origin, tt.wantErr, err)
+		}
+		if ws != nil {
+			ws.Close()
+		}
+	}
+}
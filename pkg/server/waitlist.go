@@ -0,0 +1,261 @@
+package server
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/mjudeikis/osa-labs/pkg/api"
+)
+
+const (
+	// waitlistMaxWait caps how long a single long-poll request is held
+	waitlistMaxWait = time.Minute
+	// waitlistRetry is how often a waiting request retries even if nothing
+	// signalled a change
+	waitlistRetry = 5 * time.Second
+	// waitlistStale drops participants who stopped polling from the queue
+	waitlistStale = 2 * time.Minute
+)
+
+// Pools participants queue for
+const (
+	poolCredentials = "credentials"
+	poolWorkers     = "workers"
+)
+
+// turn is the number of participants queued in front of a participant for
+// each pool
+type turn map[string]int
+
+type waitEntry struct {
+	claim    string
+	lastSeen time.Time
+}
+
+// waitlist keeps a FIFO queue per pool of participants waiting for a free
+// credential or worker, so a participant holding a credential and waiting for
+// a worker does not hold back credentials. Waiters are woken up whenever the
+// pools may have changed.
+type waitlist struct {
+	sync.Mutex
+	queues map[string][]waitEntry
+	notify chan struct{}
+}
+
+func newWaitlist() *waitlist {
+	return &waitlist{
+		queues: map[string][]waitEntry{},
+		notify: make(chan struct{}),
+	}
+}
+
+// changed returns a channel which is closed on the next broadcast
+func (q *waitlist) changed() <-chan struct{} {
+	q.Lock()
+	defer q.Unlock()
+	return q.notify
+}
+
+// broadcast wakes up all waiters
+func (q *waitlist) broadcast() {
+	q.Lock()
+	defer q.Unlock()
+	close(q.notify)
+	q.notify = make(chan struct{})
+}
+
+// ahead returns the number of participants queued for the pool in front of
+// the claim. A claim which is not queued is behind everybody.
+func (q *waitlist) ahead(pool, claim string) int {
+	q.Lock()
+	defer q.Unlock()
+	q.prune(time.Now())
+	for i, e := range q.queues[pool] {
+		if e.claim == claim {
+			return i
+		}
+	}
+	return len(q.queues[pool])
+}
+
+// queued returns true if the claim is waiting for any pool
+func (q *waitlist) queued(claim string) bool {
+	q.Lock()
+	defer q.Unlock()
+	q.prune(time.Now())
+	for _, entries := range q.queues {
+		for _, e := range entries {
+			if e.claim == claim {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// enqueue adds the claim to the end of the pool's queue unless it is queued
+// already and returns its 1-based position. The claim keeps its place in the
+// queues of the other pools.
+func (q *waitlist) enqueue(pool, claim string) int {
+	q.Lock()
+	defer q.Unlock()
+	now := time.Now()
+	q.prune(now)
+	position := 0
+	for name, entries := range q.queues {
+		for i, e := range entries {
+			if e.claim == claim {
+				entries[i].lastSeen = now
+				if name == pool {
+					position = i + 1
+				}
+			}
+		}
+	}
+	if position > 0 {
+		return position
+	}
+	q.queues[pool] = append(q.queues[pool], waitEntry{claim: claim, lastSeen: now})
+	return len(q.queues[pool])
+}
+
+// remove takes the claim off the queues of the pools and lets the others
+// move up
+func (q *waitlist) remove(claim string, pools ...string) {
+	q.Lock()
+	removed := false
+	for _, pool := range pools {
+		entries := q.queues[pool]
+		for i, e := range entries {
+			if e.claim == claim {
+				q.queues[pool] = append(entries[:i], entries[i+1:]...)
+				removed = true
+				break
+			}
+		}
+	}
+	q.Unlock()
+	if removed {
+		q.broadcast()
+	}
+}
+
+func (q *waitlist) prune(now time.Time) {
+	for pool, entries := range q.queues {
+		kept := entries[:0]
+		for _, e := range entries {
+			if now.Sub(e.lastSeen) < waitlistStale {
+				kept = append(kept, e)
+			}
+		}
+		q.queues[pool] = kept
+	}
+}
+
+// exhaustedPool returns the pool a reservation error reports as exhausted
+func exhaustedPool(err error) (string, bool) {
+	switch err {
+	case errCredentialsExhausted:
+		return poolCredentials, true
+	case errWorkersExhausted:
+		return poolWorkers, true
+	}
+	return "", false
+}
+
+// reserveOrWait calls reserve on behalf of the participant and writes the
+// result, or the participant's waitlist position if the pools are exhausted
+func (s *Server) reserveOrWait(w http.ResponseWriter, r *http.Request, l *lab, claim string, pools []string, reserve func(ahead turn) (interface{}, error)) {
+	result, waiting, err := s.waitForTurn(r, l, claim, pools, reserve)
+	switch {
+	case err != nil:
+		s.writeError(w, err)
+	case waiting != nil:
+		s.writeJSON(w, http.StatusAccepted, waiting)
+	case result != nil:
+		s.writeJSON(w, http.StatusOK, result)
+	}
+}
+
+// waitForTurn calls reserve on behalf of the participant, handing out entries
+// of the pools in waitlist order. If a pool is exhausted the participant is
+// queued for it and gets its position back. With the wait query parameter the
+// request is held until it is the participant's turn or the wait runs out.
+// Nothing is returned if the client went away.
+func (s *Server) waitForTurn(r *http.Request, l *lab, claim string, pools []string, reserve func(ahead turn) (interface{}, error)) (interface{}, *api.Waiting, error) {
+	wait, err := waitDuration(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	deadline := time.Now().Add(wait)
+
+	for {
+		// grab the channel first so a change during reserve is not missed
+		changed := l.waitlist.changed()
+
+		ahead := turn{}
+		for _, pool := range pools {
+			ahead[pool] = l.waitlist.ahead(pool, claim)
+		}
+		result, err := reserve(ahead)
+		if err == nil {
+			l.waitlist.remove(claim, pools...)
+			return result, nil, nil
+		}
+		pool, ok := exhaustedPool(err)
+		if !ok {
+			return nil, nil, err
+		}
+
+		position := l.waitlist.enqueue(pool, claim)
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			l.log.Debugf("claim %s queued for %s at position %d", claim, pool, position)
+			return nil, &api.Waiting{
+				Claim:    claim,
+				Position: position,
+				Reason:   err.(*Error).Code,
+				Poll:     s.pollURL(r),
+			}, nil
+		}
+		if remaining > waitlistRetry {
+			remaining = waitlistRetry
+		}
+
+		select {
+		case <-changed:
+		case <-time.After(remaining):
+		case <-s.stopping:
+			// answer with the position right away so shutdown is not held
+			deadline = time.Now()
+		case <-r.Context().Done():
+			return nil, nil, nil
+		}
+	}
+}
+
+func waitDuration(r *http.Request) (time.Duration, error) {
+	value := r.URL.Query().Get("wait")
+	if value == "" {
+		return 0, nil
+	}
+	wait, err := time.ParseDuration(value)
+	if err != nil || wait < 0 {
+		return 0, badRequest("invalid wait duration %q", value)
+	}
+	if wait > waitlistMaxWait {
+		wait = waitlistMaxWait
+	}
+	return wait, nil
+}
+
+// pollURL returns the long-poll URL a queued participant should call next.
+// The claim is not part of it, clients send theirs with every poll as header,
+// cookie or claim parameter.
+func (s *Server) pollURL(r *http.Request) string {
+	query := r.URL.Query()
+	query.Del(claimParam)
+	query.Set("wait", "30s")
+	return s.hostname + r.URL.Path + "?" + query.Encode()
+}
@@ -0,0 +1,64 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/mjudeikis/osa-labs/pkg/api"
+	"github.com/mjudeikis/osa-labs/pkg/workers"
+)
+
+func TestWaitlistPools(t *testing.T) {
+	wm := workers.NewMemory(api.Worker{Name: "a"})
+	s, l := newTestServer(t, wm,
+		api.Credential{Username: "u1", Password: "p1"},
+		api.Credential{Username: "u2", Password: "p2"},
+	)
+	_, err := l.getUniqueWorker("c0", "test", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// c1 holds a credential and queues for a worker
+	w := serve(s, l, s.getCredentials, http.MethodGet, "/credentials?claim=c1", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	w = serve(s, l, s.getWorker, http.MethodGet, "/worker?claim=c1", nil)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("expected 202, got %d: %s", w.Code, w.Body.String())
+	}
+	var waiting api.Waiting
+	decode(t, w, &waiting)
+	if waiting.Position != 1 {
+		t.Errorf("expected position 1, got %d", waiting.Position)
+	}
+	if strings.Contains(waiting.Poll, "c1") {
+		t.Errorf("expected the poll URL to leave out the claim, got %s", waiting.Poll)
+	}
+
+	// which does not hold back the free credential
+	w = serve(s, l, s.getCredentials, http.MethodGet, "/credentials?claim=c2", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var cred api.Credential
+	decode(t, w, &cred)
+	if cred.Username != "u2" {
+		t.Errorf("unexpected credential %#v", cred)
+	}
+
+	// c2 queues for a worker behind c1
+	w = serve(s, l, s.getWorker, http.MethodGet, "/worker?claim=c2", nil)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("expected 202, got %d: %s", w.Code, w.Body.String())
+	}
+	decode(t, w, &waiting)
+	if waiting.Position != 2 {
+		t.Errorf("expected position 2, got %d", waiting.Position)
+	}
+	if l.waitlist.ahead(poolCredentials, "c2") != 0 {
+		t.Error("expected nobody to be queued for credentials")
+	}
+}
@@ -0,0 +1,240 @@
+package ssh
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// gatewayDialTimeout bounds connecting to the worker
+	gatewayDialTimeout = 10 * time.Second
+
+	// permission extensions carrying the target from authentication to the
+	// connection
+	extAddress = "osa-labs-address"
+	extUser    = "osa-labs-user"
+	extKey     = "osa-labs-key"
+)
+
+// Target is the worker a participant is proxied to
+type Target struct {
+	// Address is the in-cluster host:port of the worker
+	Address string
+	// User is the user the gateway logs in to the worker as
+	User string
+	// Key is the worker private key in PEM format
+	Key string
+}
+
+// Resolver looks up the worker assigned to an authenticating participant. It
+// returns nil if the participant has no worker.
+type Resolver interface {
+	// ByKey resolves a participant logging in as user with the worker key
+	ByKey(user string, key ssh.PublicKey) (*Target, error)
+	// ByPassword resolves a participant logging in with the credential
+	// username and password
+	ByPassword(user, password string) (*Target, error)
+}
+
+// Gateway accepts participants on a single address and proxies their
+// sessions to their workers, so workers need no address of their own
+type Gateway struct {
+	log      *logrus.Entry
+	address  string
+	resolver Resolver
+	config   *ssh.ServerConfig
+}
+
+// NewGateway returns a gateway listening on address with the host key
+func NewGateway(log *logrus.Entry, address string, hostKey ssh.Signer, resolver Resolver) *Gateway {
+	g := &Gateway{
+		log:      log,
+		address:  address,
+		resolver: resolver,
+	}
+	g.config = &ssh.ServerConfig{
+		PublicKeyCallback: g.publicKey,
+		PasswordCallback:  g.password,
+	}
+	g.config.AddHostKey(hostKey)
+	return g
+}
+
+func (g *Gateway) publicKey(c ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+	target, err := g.resolver.ByKey(c.User(), key)
+	return g.permissions(c, target, err)
+}
+
+func (g *Gateway) password(c ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+	target, err := g.resolver.ByPassword(c.User(), string(password))
+	return g.permissions(c, target, err)
+}
+
+// permissions passes the target on to the connection once authenticated
+func (g *Gateway) permissions(c ssh.ConnMetadata, target *Target, err error) (*ssh.Permissions, error) {
+	if err != nil {
+		g.log.Warnf("resolving worker of %q: %v", c.User(), err)
+		return nil, fmt.Errorf("resolving worker of %q failed", c.User())
+	}
+	if target == nil {
+		return nil, fmt.Errorf("no worker for %q", c.User())
+	}
+	return &ssh.Permissions{
+		Extensions: map[string]string{
+			extAddress: target.Address,
+			extUser:    target.User,
+			extKey:     target.Key,
+		},
+	}, nil
+}
+
+// Run accepts connections until the context is done
+func (g *Gateway) Run(ctx context.Context) error {
+	listener, err := net.Listen("tcp", g.address)
+	if err != nil {
+		return err
+	}
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+
+	g.log.Infof("SSH gateway listening on %s", g.address)
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			g.log.Debugf("failed to accept incoming connection (%s)", err)
+			continue
+		}
+		go g.handle(conn)
+	}
+}
+
+func (g *Gateway) handle(tcpConn net.Conn) {
+	defer tcpConn.Close()
+
+	conn, chans, reqs, err := ssh.NewServerConn(tcpConn, g.config)
+	if err != nil {
+		g.log.Debugf("failed to handshake with %s (%s)", tcpConn.RemoteAddr(), err)
+		return
+	}
+	defer conn.Close()
+	go ssh.DiscardRequests(reqs)
+
+	ext := conn.Permissions.Extensions
+	upstream, err := g.dial(ext[extAddress], ext[extUser], ext[extKey])
+	if err != nil {
+		g.log.Warnf("connecting %s to worker at %s: %v", conn.User(), ext[extAddress], err)
+		return
+	}
+	defer upstream.Close()
+
+	g.log.Infof("proxying %s (%s) to worker at %s", conn.User(), conn.RemoteAddr(), ext[extAddress])
+	go func() {
+		// the participant loses the session when the worker goes away
+		upstream.Wait()
+		conn.Close()
+	}()
+
+	var wg sync.WaitGroup
+	for newChannel := range chans {
+		wg.Add(1)
+		go func(newChannel ssh.NewChannel) {
+			defer wg.Done()
+			proxyChannel(upstream, newChannel)
+		}(newChannel)
+	}
+	wg.Wait()
+}
+
+func (g *Gateway) dial(address, user, key string) (*ssh.Client, error) {
+	if address == "" {
+		return nil, fmt.Errorf("worker has no address")
+	}
+	signer, err := ssh.ParsePrivateKey([]byte(key))
+	if err != nil {
+		return nil, err
+	}
+	return ssh.Dial("tcp", address, &ssh.ClientConfig{
+		User: user,
+		Auth: []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		// worker host keys are generated on start and not known to us
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		Timeout:         gatewayDialTimeout,
+	})
+}
+
+// proxyChannel opens the same channel on the worker and copies data and
+// requests both ways until either side closes it
+func proxyChannel(upstream *ssh.Client, newChannel ssh.NewChannel) {
+	upChannel, upRequests, err := upstream.OpenChannel(newChannel.ChannelType(), newChannel.ExtraData())
+	if err != nil {
+		if openErr, ok := err.(*ssh.OpenChannelError); ok {
+			newChannel.Reject(openErr.Reason, openErr.Message)
+		} else {
+			newChannel.Reject(ssh.ConnectionFailed, err.Error())
+		}
+		return
+	}
+	defer upChannel.Close()
+
+	channel, requests, err := newChannel.Accept()
+	if err != nil {
+		return
+	}
+	defer channel.Close()
+
+	go func() {
+		forwardRequests(upChannel, requests)
+		// the participant closed the channel
+		upChannel.Close()
+	}()
+	upDone := make(chan struct{})
+	go func() {
+		forwardRequests(channel, upRequests)
+		close(upDone)
+	}()
+
+	go func() {
+		io.Copy(upChannel, channel)
+		upChannel.CloseWrite()
+	}()
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		io.Copy(channel, upChannel)
+	}()
+	go func() {
+		defer wg.Done()
+		io.Copy(channel.Stderr(), upChannel.Stderr())
+	}()
+	wg.Wait()
+	channel.CloseWrite()
+
+	// pass on the exit status, which the worker sends before closing
+	<-upDone
+}
+
+// forwardRequests sends channel requests on to dst and relays the replies
+func forwardRequests(dst ssh.Channel, requests <-chan *ssh.Request) {
+	for req := range requests {
+		ok, err := dst.SendRequest(req.Type, req.WantReply, req.Payload)
+		if err != nil {
+			ok = false
+		}
+		if req.WantReply {
+			req.Reply(ok, nil)
+		}
+	}
+}
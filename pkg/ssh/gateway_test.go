@@ -0,0 +1,184 @@
+package ssh
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"net"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/mjudeikis/osa-labs/pkg/utils/keygen"
+)
+
+type testResolver struct {
+	target *Target
+	key    ssh.PublicKey
+}
+
+func (r *testResolver) ByKey(user string, key ssh.PublicKey) (*Target, error) {
+	if user == "worker" && bytes.Equal(key.Marshal(), r.key.Marshal()) {
+		return r.target, nil
+	}
+	return nil, nil
+}
+
+func (r *testResolver) ByPassword(user, password string) (*Target, error) {
+	if user == "u1" && password == "p1" {
+		return r.target, nil
+	}
+	return nil, nil
+}
+
+// listen accepts connections on a local port until the test ends
+func listen(t *testing.T, handle func(net.Conn)) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go handle(conn)
+		}
+	}()
+	return listener.Addr().String()
+}
+
+// serveWorker answers exec requests with the command and exit status 3
+func serveWorker(t *testing.T, config *ssh.ServerConfig) func(net.Conn) {
+	return func(conn net.Conn) {
+		_, chans, reqs, err := ssh.NewServerConn(conn, config)
+		if err != nil {
+			return
+		}
+		go ssh.DiscardRequests(reqs)
+		for newChannel := range chans {
+			channel, requests, err := newChannel.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				for req := range requests {
+					req.Reply(req.Type == "exec", nil)
+					if req.Type != "exec" {
+						continue
+					}
+					channel.Write([]byte("ran " + string(req.Payload[4:])))
+					channel.Stderr().Write([]byte("warning"))
+					channel.SendRequest("exit-status", false, []byte{0, 0, 0, 3})
+					channel.Close()
+				}
+			}()
+		}
+	}
+}
+
+func TestGateway(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pem, err := keygen.PrivateKeyAsBytes(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.ParsePrivateKey(pem)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	workerConfig := &ssh.ServerConfig{
+		PublicKeyCallback: func(c ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if c.User() != "lab" || !bytes.Equal(key.Marshal(), signer.PublicKey().Marshal()) {
+				t.Errorf("worker login as %s", c.User())
+			}
+			return nil, nil
+		},
+	}
+	workerConfig.AddHostKey(signer)
+	workerAddress := listen(t, serveWorker(t, workerConfig))
+
+	resolver := &testResolver{
+		target: &Target{Address: workerAddress, User: "lab", Key: string(pem)},
+		key:    signer.PublicKey(),
+	}
+	g := NewGateway(logrus.NewEntry(logrus.StandardLogger()), "", signer, resolver)
+	gatewayAddress := listen(t, g.handle)
+
+	for _, tt := range []struct {
+		name    string
+		user    string
+		auth    ssh.AuthMethod
+		wantErr bool
+	}{
+		{name: "worker key", user: "worker", auth: ssh.PublicKeys(signer)},
+		{name: "credential", user: "u1", auth: ssh.Password("p1")},
+		{name: "wrong password", user: "u1", auth: ssh.Password("p2"), wantErr: true},
+		{name: "unknown worker", user: "other", auth: ssh.PublicKeys(signer), wantErr: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			client, err := ssh.Dial("tcp", gatewayAddress, &ssh.ClientConfig{
+				User:            tt.user,
+				Auth:            []ssh.AuthMethod{tt.auth},
+				HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+			})
+			if tt.wantErr {
+				if err == nil {
+					client.Close()
+					t.Fatal("expected the login to be rejected")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer client.Close()
+
+			session, err := client.NewSession()
+			if err != nil {
+				t.Fatal(err)
+			}
+			var stdout, stderr bytes.Buffer
+			session.Stdout = &stdout
+			session.Stderr = &stderr
+			err = session.Run("hostname")
+			if exitErr, ok := err.(*ssh.ExitError); !ok || exitErr.ExitStatus() != 3 {
+				t.Errorf("expected exit status 3, got %v", err)
+			}
+			if stdout.String() != "ran hostname" || stderr.String() != "warning" {
+				t.Errorf("unexpected output %q, %q", stdout.String(), stderr.String())
+			}
+		})
+	}
+}
+
+func TestGatewayRun(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := NewGateway(logrus.NewEntry(logrus.StandardLogger()), "127.0.0.1:0", signer, &testResolver{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- g.Run(ctx)
+	}()
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("expected a clean shutdown, got %v", err)
+	}
+}
@@ -2,7 +2,6 @@ package ssh
 
 import (
 	"encoding/binary"
-	"flag"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -19,19 +18,16 @@ import (
 	"golang.org/x/crypto/ssh"
 )
 
-var (
-	address = flag.String("address", ":2222", "Bind address")
-)
-
 type Server struct {
 	log               *logrus.Entry
-	port              string
+	address           string
+	hostKey           string
 	username          string
 	password          string
 	authorizedKeysMap map[string]bool
 }
 
-func New(log *logrus.Entry, port, pubKey string) (*Server, error) {
+func New(log *logrus.Entry, address, pubKey, hostKey string) (*Server, error) {
 	log.Debugf("starting with key %s", pubKey)
 	authorizedKeysBytes, err := ioutil.ReadFile(pubKey)
 	if err != nil {
@@ -52,7 +48,8 @@ func New(log *logrus.Entry, port, pubKey string) (*Server, error) {
 
 	return &Server{
 		log:               log,
-		port:              port,
+		address:           address,
+		hostKey:           hostKey,
 		username:          os.Getenv("SSH_USERNAME"),
 		password:          os.Getenv("SSH_PASSWORD"),
 		authorizedKeysMap: authorizedKeysMap,
@@ -87,9 +84,9 @@ func (s Server) Run() error {
 	}
 
 	// You can generate a keypair with 'ssh-keygen -t rsa'
-	privateBytes, err := ioutil.ReadFile("/data/id_rsa")
+	privateBytes, err := ioutil.ReadFile(s.hostKey)
 	if err != nil {
-		s.log.Fatal("Failed to load private key (/data/id_rsa)")
+		s.log.Fatalf("Failed to load private key (%s)", s.hostKey)
 	}
 
 	private, err := ssh.ParsePrivateKey(privateBytes)
@@ -100,13 +97,13 @@ func (s Server) Run() error {
 	config.AddHostKey(private)
 
 	// Once a ServerConfig has been configured, connections can be accepted.
-	listener, err := net.Listen("tcp", fmt.Sprintf("0.0.0.0:%s", s.port))
+	listener, err := net.Listen("tcp", s.address)
 	if err != nil {
-		s.log.Fatalf("Failed to listen on %s (%s)", s.port, err)
+		s.log.Fatalf("Failed to listen on %s (%s)", s.address, err)
 	}
 
 	// Accept all connections
-	s.log.Infof("Listening on %s", s.port)
+	s.log.Infof("Listening on %s", s.address)
 	for {
 		tcpConn, err := listener.Accept()
 		if err != nil {
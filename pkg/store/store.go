@@ -6,8 +6,11 @@ import (
 	"os"
 	"path/filepath"
 	"sync"
+	"time"
 
 	"github.com/sirupsen/logrus"
+
+	"github.com/mjudeikis/osa-labs/pkg/metrics"
 )
 
 type Store interface {
@@ -47,6 +50,7 @@ func New(log *logrus.Entry, dir, namespace string) (Store, error) {
 }
 
 func (s *Storage) Put(key string, b []byte) error {
+	defer s.observe("put", time.Now())
 	if key == "" {
 		return fmt.Errorf("missing key - unable to save")
 	}
@@ -75,6 +79,7 @@ func (s *Storage) Put(key string, b []byte) error {
 
 // Get a record from the database
 func (s *Storage) Get(key string) ([]byte, error) {
+	defer s.observe("get", time.Now())
 	if key == "" {
 		return nil, fmt.Errorf("missing key - unable to read")
 	}
@@ -90,6 +95,7 @@ func (s *Storage) Get(key string) ([]byte, error) {
 }
 
 func (s *Storage) Delete(key string) error {
+	defer s.observe("delete", time.Now())
 	path := filepath.Join(s.namespace, key)
 	//
 	mutex := s.getMutex(s.namespace)
@@ -111,6 +117,10 @@ func (s *Storage) Delete(key string) error {
 	return nil
 }
 
+func (s *Storage) observe(operation string, start time.Time) {
+	metrics.StoreDuration.WithLabelValues(s.namespace, operation).Observe(time.Since(start).Seconds())
+}
+
 func stat(path string) (fi os.FileInfo, err error) {
 	// check for dir, if path isn't a directory check to see if it's a file
 	if fi, err = os.Stat(path); os.IsNotExist(err) {
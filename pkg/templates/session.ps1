@@ -0,0 +1,27 @@
+$T = Join-Path ([IO.Path]::GetTempPath()) ([IO.Path]::GetRandomFileName())
+New-Item -ItemType Directory -Path $T | Out-Null
+$Key = Join-Path $T "id_rsa"
+Set-Content -Path $Key -Value @'
+{{.Session.Worker.SSHKey}}
+'@
+# ssh refuses keys other users can read
+icacls $Key /inheritance:r /grant:r "$($env:USERNAME):(R)" | Out-Null
+
+Write-Host ""
+Write-Host "            Your Azure Portal credentials are:"
+Write-Host ""
+Write-Host ("Username: " + {{ps .Session.Credential.Username}})
+Write-Host ("Password: " + {{ps .Session.Credential.Password}})
+
+Write-Host "Portal URL: https://portal.azure.com"
+
+Write-Host "Configuring workstation with unique ssh key to the bastion host..."
+Start-Sleep -Seconds 2
+Write-Host ""
+Write-Host "ssh command:"
+Write-Host ""
+Write-Host ("ssh -l " + {{ps .Session.Worker.User}} + " " + {{ps .Session.Worker.Host}} + " -p {{.Session.Worker.Port}} -i " + $Key)
+Write-Host ""
+Write-Host "If port {{.Port}} is blocked on this workstation, open a terminal in your browser:"
+Write-Host {{ps (printf "%s/lab/terminal?claim=%s" .Hostname .Claim)}}
+Write-Host ""
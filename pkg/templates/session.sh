@@ -0,0 +1,24 @@
+T="$(mktemp -d)"
+cat > "${T}/id_rsa" <<'OSA_LABS_KEY'
+{{.Session.Worker.SSHKey}}
+OSA_LABS_KEY
+chmod 600 "${T}/id_rsa"
+
+echo ""
+echo "            Your Azure Portal credentials are:"
+echo ""
+echo "Username: "{{sh .Session.Credential.Username}}
+echo "Password: "{{sh .Session.Credential.Password}}
+
+echo "Portal URL: https://portal.azure.com"
+
+echo "Configuring workstation with unique ssh key to the bastion host..."
+sleep 2
+echo ""
+echo "ssh command:"
+echo ""
+echo "ssh -l "{{sh .Session.Worker.User}}" "{{sh .Session.Worker.Host}}" -p {{.Session.Worker.Port}} -i ${T}/id_rsa"
+echo ""
+echo "If port {{.Port}} is blocked on this workstation, open a terminal in your browser:"
+echo {{sh (printf "%s/lab/terminal?claim=%s" .Hostname .Claim)}}
+echo ""
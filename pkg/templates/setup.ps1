@@ -0,0 +1,45 @@
+Write-Host ""
+Write-Host ""
+Write-Host ("                   Welcome to " + {{ps .Title}})
+
+$ResourceUrl = {{ps .Hostname}}
+
+# reuse the claim from a previous run so we get the same credentials back
+$ClaimFile = Join-Path $HOME ".osa-labs-claim"
+if ((Test-Path $ClaimFile) -and (Get-Item $ClaimFile).Length -gt 0) {
+    $Claim = (Get-Content $ClaimFile -Raw).Trim()
+} else {
+    $Claim = {{ps .Claim}}
+    Set-Content -Path $ClaimFile -Value $Claim
+}
+
+[Net.ServicePointManager]::SecurityProtocol = [Net.SecurityProtocolType]::Tls12
+
+# when all environments are taken we are queued, keep polling until it is our turn
+while ($true) {
+    try {
+        $Response = Invoke-WebRequest -UseBasicParsing -Headers @{ "X-Claim-ID" = $Claim } -Uri "$ResourceUrl/setup?flavour=ps1&stage=session&wait=30s"
+    } catch {
+        $Message = ""
+        try {
+            $Message = ($_.ErrorDetails.Message | ConvertFrom-Json).error.message
+        } catch {
+        }
+        Write-Host ""
+        Write-Host "Sorry, we could not set up your lab environment ($($_.Exception.Message))."
+        if ($Message) {
+            Write-Host $Message
+        }
+        Write-Host "Please try again in a minute or ask one of the lab organisers for help."
+        Write-Host ""
+        return
+    }
+    if ($Response.StatusCode -ne 202) {
+        break
+    }
+    $Waiting = $Response.Content | ConvertFrom-Json
+    Write-Host "All lab environments are taken right now. You are number $($Waiting.position) in the queue, waiting..."
+}
+
+# the session is rendered by the server as a script, no JSON parsing needed
+Invoke-Expression $Response.Content
@@ -0,0 +1,42 @@
+#!/bin/sh
+echo ""
+echo ""
+echo "                   Welcome to "{{sh .Title}}
+
+RESOURCE_URL={{sh .Hostname}}
+
+# reuse the claim from a previous run so we get the same credentials back
+CLAIM_FILE="${HOME}/.osa-labs-claim"
+if [ -s "${CLAIM_FILE}" ]; then
+    CLAIM=$(cat "${CLAIM_FILE}")
+else
+    CLAIM={{sh .Claim}}
+    echo "${CLAIM}" > "${CLAIM_FILE}"
+fi
+
+# when all environments are taken we are queued, keep polling until it is our turn
+while true; do
+    RESPONSE=$(curl -sSk -w "\n%{http_code}" -H "X-Claim-ID: ${CLAIM}" "${RESOURCE_URL}/setup?flavour=sh&stage=session&wait=30s")
+    STATUS=$(echo "${RESPONSE}" | tail -n1)
+    BODY=$(echo "${RESPONSE}" | sed '$d')
+    if [ "${STATUS}" != "202" ]; then
+        break
+    fi
+    POSITION=$(echo "${BODY}" | sed -n 's/.*"position":\([0-9]*\).*/\1/p')
+    echo "All lab environments are taken right now. You are number ${POSITION} in the queue, waiting..."
+done
+
+if [ "${STATUS}" != "200" ]; then
+    MESSAGE=$(echo "${BODY}" | sed -n 's/.*"message":"\([^"]*\)".*/\1/p')
+    echo ""
+    echo "Sorry, we could not set up your lab environment (HTTP ${STATUS})."
+    if [ -n "${MESSAGE}" ]; then
+        echo "${MESSAGE}"
+    fi
+    echo "Please try again in a minute or ask one of the lab organisers for help."
+    echo ""
+    exit 1
+fi
+
+# the session is rendered by the server as a script, no JSON parsing needed
+printf "%s\n" "${BODY}" | sh
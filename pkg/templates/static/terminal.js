@@ -0,0 +1,663 @@
+// Terminal renders the output of a shell session into an element and reports
+// what is typed into it. It understands the VT100 and xterm escape sequences
+// used by shells and common full screen programs; it is served with the other
+// static assets so the terminal page loads nothing from other origins.
+(function() {
+  'use strict';
+
+  var historyLimit = 1000;
+  var defaultFg = '#f0f0f0';
+  var defaultBg = '#000000';
+  var palette = [
+    '#000000', '#cd0000', '#00cd00', '#cdcd00', '#0000ee', '#cd00cd', '#00cdcd', '#e5e5e5',
+    '#7f7f7f', '#ff0000', '#00ff00', '#ffff00', '#5c5cff', '#ff00ff', '#00ffff', '#ffffff'
+  ];
+  var keys = {
+    Enter: '\r', Backspace: '\x7f', Tab: '\t', Escape: '\x1b',
+    Home: '\x1b[H', End: '\x1b[F', Insert: '\x1b[2~', Delete: '\x1b[3~',
+    PageUp: '\x1b[5~', PageDown: '\x1b[6~',
+    F1: '\x1bOP', F2: '\x1bOQ', F3: '\x1bOR', F4: '\x1bOS',
+    F5: '\x1b[15~', F6: '\x1b[17~', F7: '\x1b[18~', F8: '\x1b[19~',
+    F9: '\x1b[20~', F10: '\x1b[21~', F11: '\x1b[23~', F12: '\x1b[24~'
+  };
+  var arrows = {ArrowUp: 'A', ArrowDown: 'B', ArrowRight: 'C', ArrowLeft: 'D'};
+
+  function color(n) {
+    if (n < 16) {
+      return palette[n];
+    }
+    if (n < 232) {
+      n -= 16;
+      return rgb([Math.floor(n / 36), Math.floor(n / 6) % 6, n % 6].map(function(v) {
+        return v ? v * 40 + 55 : 0;
+      }));
+    }
+    var grey = (n - 232) * 10 + 8;
+    return rgb([grey, grey, grey]);
+  }
+
+  function rgb(values) {
+    return 'rgb(' + values.join(',') + ')';
+  }
+
+  function escapeHTML(s) {
+    return s.replace(/&/g, '&amp;').replace(/</g, '&lt;').replace(/>/g, '&gt;');
+  }
+
+  function blankLine(cols) {
+    var line = [];
+    for (var i = 0; i < cols; i++) {
+      line.push({c: ' ', s: ''});
+    }
+    return line;
+  }
+
+  function Terminal(element) {
+    this.element = element;
+    this.element.tabIndex = 0;
+    this.historyElement = document.createElement('pre');
+    this.screenElement = document.createElement('pre');
+    this.element.appendChild(this.historyElement);
+    this.element.appendChild(this.screenElement);
+    this.history = 0;
+
+    this.onData = function() {};
+    this.onResize = function() {};
+
+    this.cols = 80;
+    this.rows = 24;
+    this.reset();
+
+    var term = this;
+    this.element.addEventListener('keydown', function(event) {
+      term.keydown(event);
+    });
+    this.element.addEventListener('paste', function(event) {
+      event.preventDefault();
+      term.onData(event.clipboardData.getData('text').replace(/\r?\n/g, '\r'));
+    });
+  }
+
+  Terminal.prototype.reset = function() {
+    this.lines = [];
+    for (var i = 0; i < this.rows; i++) {
+      this.lines.push(blankLine(this.cols));
+    }
+    this.x = 0;
+    this.y = 0;
+    this.wrapPending = false;
+    this.saved = {x: 0, y: 0};
+    this.scrollTop = 0;
+    this.scrollBottom = this.rows - 1;
+    this.style = {};
+    this.css = '';
+    this.cursorVisible = true;
+    this.autowrap = true;
+    this.appCursor = false;
+    this.main = null;
+    this.state = 'ground';
+    this.params = '';
+    this.render();
+  };
+
+  Terminal.prototype.focus = function() {
+    this.element.focus();
+  };
+
+  // fit sizes the terminal to its element
+  Terminal.prototype.fit = function() {
+    var probe = document.createElement('span');
+    probe.textContent = 'WWWWWWWWWW';
+    this.screenElement.appendChild(probe);
+    var rect = probe.getBoundingClientRect();
+    this.screenElement.removeChild(probe);
+    if (!rect.width || !rect.height) {
+      return;
+    }
+
+    var style = getComputedStyle(this.element);
+    var width = this.element.clientWidth - parseFloat(style.paddingLeft) - parseFloat(style.paddingRight);
+    var height = this.element.clientHeight - parseFloat(style.paddingTop) - parseFloat(style.paddingBottom);
+    var cols = Math.max(2, Math.floor(width / (rect.width / 10)));
+    var rows = Math.max(2, Math.floor(height / rect.height));
+    if (cols !== this.cols || rows !== this.rows) {
+      this.resize(cols, rows);
+      this.onResize({cols: cols, rows: rows});
+    }
+  };
+
+  Terminal.prototype.resize = function(cols, rows) {
+    var resizeLines = function(lines) {
+      return lines.map(function(line) {
+        return line.length > cols ? line.slice(0, cols) : line.concat(blankLine(cols - line.length));
+      });
+    };
+    this.lines = resizeLines(this.lines);
+    if (this.main) {
+      this.main.lines = resizeLines(this.main.lines);
+    }
+    // lines above a shrunk screen go to the history so the cursor stays
+    while (this.lines.length > rows) {
+      if (this.y > 0) {
+        this.pushHistory(this.lines.shift());
+        this.y--;
+      } else {
+        this.lines.pop();
+      }
+    }
+    while (this.lines.length < rows) {
+      this.lines.push(blankLine(cols));
+    }
+    this.cols = cols;
+    this.rows = rows;
+    this.scrollTop = 0;
+    this.scrollBottom = rows - 1;
+    this.moveTo(this.x, this.y);
+    this.render();
+  };
+
+  Terminal.prototype.write = function(data) {
+    for (var i = 0; i < data.length; i++) {
+      this.consume(data[i]);
+    }
+    this.scheduleRender();
+  };
+
+  Terminal.prototype.consume = function(ch) {
+    switch (this.state) {
+    case 'escape':
+      this.state = 'ground';
+      this.escape(ch);
+      return;
+    case 'csi':
+      if (ch >= '@' && ch <= '~') {
+        this.state = 'ground';
+        this.csi(ch, this.params);
+      } else if (ch >= '0' && ch <= '?') {
+        this.params += ch;
+      }
+      return;
+    case 'osc':
+      // window titles and the like are not shown
+      if (ch === '\x07') {
+        this.state = 'ground';
+      } else if (ch === '\x1b') {
+        this.state = 'escape';
+      }
+      return;
+    case 'charset':
+      this.state = 'ground';
+      return;
+    }
+
+    switch (ch) {
+    case '\x1b':
+      this.state = 'escape';
+      break;
+    case '\r':
+      this.moveTo(0, this.y);
+      break;
+    case '\n':
+    case '\x0b':
+    case '\x0c':
+      this.lineFeed();
+      break;
+    case '\b':
+      this.moveTo(this.x - 1, this.y);
+      break;
+    case '\t':
+      this.moveTo((Math.floor(this.x / 8) + 1) * 8, this.y);
+      break;
+    default:
+      if (ch >= ' ' && ch !== '\x7f') {
+        this.put(ch);
+      }
+    }
+  };
+
+  Terminal.prototype.escape = function(ch) {
+    switch (ch) {
+    case '[':
+      this.state = 'csi';
+      this.params = '';
+      break;
+    case ']':
+      this.state = 'osc';
+      break;
+    case '(':
+    case ')':
+    case '*':
+    case '+':
+      this.state = 'charset';
+      break;
+    case '7':
+      this.saveCursor();
+      break;
+    case '8':
+      this.restoreCursor();
+      break;
+    case 'D':
+      this.lineFeed();
+      break;
+    case 'E':
+      this.moveTo(0, this.y);
+      this.lineFeed();
+      break;
+    case 'M':
+      if (this.y === this.scrollTop) {
+        this.scrollDown(1);
+      } else {
+        this.moveTo(this.x, this.y - 1);
+      }
+      break;
+    case 'c':
+      this.reset();
+      break;
+    }
+  };
+
+  Terminal.prototype.csi = function(final, params) {
+    var priv = params[0] === '?';
+    var args = params.replace(/^[?>=]/, '').split(';').map(function(v) {
+      return parseInt(v, 10) || 0;
+    });
+    var n = args[0] || 1;
+    var line = this.lines[this.y];
+    var i;
+
+    switch (final) {
+    case '@':
+      for (i = 0; i < n; i++) {
+        line.splice(this.x, 0, {c: ' ', s: ''});
+      }
+      line.length = this.cols;
+      break;
+    case 'A':
+      this.moveTo(this.x, this.y - n);
+      break;
+    case 'B':
+      this.moveTo(this.x, this.y + n);
+      break;
+    case 'C':
+      this.moveTo(this.x + n, this.y);
+      break;
+    case 'D':
+      this.moveTo(this.x - n, this.y);
+      break;
+    case 'E':
+      this.moveTo(0, this.y + n);
+      break;
+    case 'F':
+      this.moveTo(0, this.y - n);
+      break;
+    case 'G':
+    case '`':
+      this.moveTo(n - 1, this.y);
+      break;
+    case 'd':
+      this.moveTo(this.x, n - 1);
+      break;
+    case 'H':
+    case 'f':
+      this.moveTo((args[1] || 1) - 1, n - 1);
+      break;
+    case 'J':
+      if (args[0] === 0) {
+        this.erase(this.y, this.x, this.cols);
+        this.eraseLines(this.y + 1, this.rows);
+      } else if (args[0] === 1) {
+        this.eraseLines(0, this.y);
+        this.erase(this.y, 0, this.x + 1);
+      } else {
+        this.eraseLines(0, this.rows);
+      }
+      break;
+    case 'K':
+      if (args[0] === 0) {
+        this.erase(this.y, this.x, this.cols);
+      } else if (args[0] === 1) {
+        this.erase(this.y, 0, this.x + 1);
+      } else {
+        this.erase(this.y, 0, this.cols);
+      }
+      break;
+    case 'L':
+    case 'M':
+      if (this.y >= this.scrollTop && this.y <= this.scrollBottom) {
+        for (i = 0; i < n; i++) {
+          if (final === 'L') {
+            this.lines.splice(this.scrollBottom, 1);
+            this.lines.splice(this.y, 0, blankLine(this.cols));
+          } else {
+            this.lines.splice(this.y, 1);
+            this.lines.splice(this.scrollBottom, 0, blankLine(this.cols));
+          }
+        }
+        this.moveTo(0, this.y);
+      }
+      break;
+    case 'P':
+      line.splice(this.x, n);
+      while (line.length < this.cols) {
+        line.push({c: ' ', s: ''});
+      }
+      break;
+    case 'X':
+      this.erase(this.y, this.x, this.x + n);
+      break;
+    case 'S':
+      this.scrollUp(n);
+      break;
+    case 'T':
+      this.scrollDown(n);
+      break;
+    case 'm':
+      this.sgr(args);
+      break;
+    case 'r':
+      this.scrollTop = Math.min((args[0] || 1) - 1, this.rows - 1);
+      this.scrollBottom = Math.min((args[1] || this.rows) - 1, this.rows - 1);
+      this.moveTo(0, 0);
+      break;
+    case 's':
+      this.saveCursor();
+      break;
+    case 'u':
+      this.restoreCursor();
+      break;
+    case 'h':
+    case 'l':
+      if (priv) {
+        this.mode(args, final === 'h');
+      }
+      break;
+    case 'n':
+      if (args[0] === 6) {
+        this.onData('\x1b[' + (this.y + 1) + ';' + (this.x + 1) + 'R');
+      } else if (args[0] === 5) {
+        this.onData('\x1b[0n');
+      }
+      break;
+    case 'c':
+      if (params === '' || params === '0') {
+        this.onData('\x1b[?1;2c');
+      }
+      break;
+    }
+  };
+
+  Terminal.prototype.mode = function(args, set) {
+    for (var i = 0; i < args.length; i++) {
+      switch (args[i]) {
+      case 1:
+        this.appCursor = set;
+        break;
+      case 7:
+        this.autowrap = set;
+        break;
+      case 25:
+        this.cursorVisible = set;
+        break;
+      case 47:
+      case 1047:
+      case 1049:
+        this.alternateScreen(set, args[i] === 1049);
+        break;
+      }
+    }
+  };
+
+  // alternateScreen switches to a screen without history, e.g. for editors,
+  // and back to the shell as it was left
+  Terminal.prototype.alternateScreen = function(on, cursor) {
+    if (on && !this.main) {
+      if (cursor) {
+        this.saveCursor();
+      }
+      this.main = {lines: this.lines};
+      this.lines = [];
+      for (var i = 0; i < this.rows; i++) {
+        this.lines.push(blankLine(this.cols));
+      }
+    } else if (!on && this.main) {
+      this.lines = this.main.lines;
+      this.main = null;
+      if (cursor) {
+        this.restoreCursor();
+      }
+    }
+  };
+
+  Terminal.prototype.sgr = function(args) {
+    var style = this.style;
+    for (var i = 0; i < args.length; i++) {
+      var a = args[i];
+      if (a === 0) {
+        style = {};
+      } else if (a === 1) {
+        style.bold = true;
+      } else if (a === 2) {
+        style.dim = true;
+      } else if (a === 3) {
+        style.italic = true;
+      } else if (a === 4) {
+        style.underline = true;
+      } else if (a === 7) {
+        style.inverse = true;
+      } else if (a === 22) {
+        style.bold = style.dim = false;
+      } else if (a === 23) {
+        style.italic = false;
+      } else if (a === 24) {
+        style.underline = false;
+      } else if (a === 27) {
+        style.inverse = false;
+      } else if (a >= 30 && a <= 37) {
+        style.fg = color(a - 30);
+      } else if (a >= 90 && a <= 97) {
+        style.fg = color(a - 90 + 8);
+      } else if (a === 39) {
+        style.fg = null;
+      } else if (a >= 40 && a <= 47) {
+        style.bg = color(a - 40);
+      } else if (a >= 100 && a <= 107) {
+        style.bg = color(a - 100 + 8);
+      } else if (a === 49) {
+        style.bg = null;
+      } else if (a === 38 || a === 48) {
+        var value = null;
+        if (args[i + 1] === 5) {
+          value = color(args[i + 2] & 255);
+          i += 2;
+        } else if (args[i + 1] === 2) {
+          value = rgb(args.slice(i + 2, i + 5));
+          i += 4;
+        }
+        if (a === 38) {
+          style.fg = value;
+        } else {
+          style.bg = value;
+        }
+      }
+    }
+    this.style = style;
+
+    var fg = style.fg || defaultFg;
+    var bg = style.bg || null;
+    if (style.inverse) {
+      bg = fg;
+      fg = style.bg || defaultBg;
+    }
+    var css = fg !== defaultFg ? 'color:' + fg + ';' : '';
+    css += bg ? 'background:' + bg + ';' : '';
+    css += style.bold ? 'font-weight:bold;' : '';
+    css += style.dim ? 'opacity:.7;' : '';
+    css += style.italic ? 'font-style:italic;' : '';
+    css += style.underline ? 'text-decoration:underline;' : '';
+    this.css = css;
+  };
+
+  Terminal.prototype.put = function(ch) {
+    if (this.wrapPending) {
+      this.moveTo(0, this.y);
+      this.lineFeed();
+    }
+    this.lines[this.y][this.x] = {c: ch, s: this.css};
+    if (this.x === this.cols - 1) {
+      this.wrapPending = this.autowrap;
+    } else {
+      this.x++;
+    }
+  };
+
+  Terminal.prototype.moveTo = function(x, y) {
+    this.x = Math.max(0, Math.min(this.cols - 1, x));
+    this.y = Math.max(0, Math.min(this.rows - 1, y));
+    this.wrapPending = false;
+  };
+
+  Terminal.prototype.saveCursor = function() {
+    this.saved = {x: this.x, y: this.y, style: this.style, css: this.css};
+  };
+
+  Terminal.prototype.restoreCursor = function() {
+    this.moveTo(this.saved.x, this.saved.y);
+    if (this.saved.style) {
+      this.style = this.saved.style;
+      this.css = this.saved.css;
+    }
+  };
+
+  Terminal.prototype.lineFeed = function() {
+    if (this.y === this.scrollBottom) {
+      this.scrollUp(1);
+    } else {
+      this.moveTo(this.x, this.y + 1);
+    }
+  };
+
+  Terminal.prototype.scrollUp = function(n) {
+    for (var i = 0; i < n; i++) {
+      var line = this.lines.splice(this.scrollTop, 1)[0];
+      this.lines.splice(this.scrollBottom, 0, blankLine(this.cols));
+      if (this.scrollTop === 0 && !this.main) {
+        this.pushHistory(line);
+      }
+    }
+  };
+
+  Terminal.prototype.scrollDown = function(n) {
+    for (var i = 0; i < n; i++) {
+      this.lines.splice(this.scrollBottom, 1);
+      this.lines.splice(this.scrollTop, 0, blankLine(this.cols));
+    }
+  };
+
+  Terminal.prototype.erase = function(y, from, to) {
+    for (var x = from; x < Math.min(to, this.cols); x++) {
+      this.lines[y][x] = {c: ' ', s: ''};
+    }
+  };
+
+  Terminal.prototype.eraseLines = function(from, to) {
+    for (var y = from; y < to; y++) {
+      this.lines[y] = blankLine(this.cols);
+    }
+  };
+
+  // pushHistory keeps a line which scrolled off the screen, the oldest lines
+  // are dropped
+  Terminal.prototype.pushHistory = function(line) {
+    this.historyElement.insertAdjacentHTML('beforeend', this.renderLine(line, -1) + '\n');
+    this.history++;
+    if (this.history > historyLimit) {
+      this.historyElement.removeChild(this.historyElement.firstChild);
+      this.history--;
+    }
+  };
+
+  Terminal.prototype.renderLine = function(line, cursor) {
+    var html = '';
+    var run = '';
+    var css = null;
+    var flush = function() {
+      if (run) {
+        html += css ? '<span style="' + css + '">' + escapeHTML(run) + '</span>' : escapeHTML(run);
+      }
+      run = '';
+    };
+    for (var x = 0; x < line.length; x++) {
+      if (x === cursor) {
+        flush();
+        html += '<span class="terminal-cursor">' + escapeHTML(line[x].c) + '</span>';
+        continue;
+      }
+      if (line[x].s !== css) {
+        flush();
+        css = line[x].s;
+      }
+      run += line[x].c;
+    }
+    flush();
+    return html.replace(/\s+$/, '');
+  };
+
+  Terminal.prototype.scheduleRender = function() {
+    if (this.renderPending) {
+      return;
+    }
+    this.renderPending = true;
+    var term = this;
+    requestAnimationFrame(function() {
+      term.renderPending = false;
+      term.render();
+    });
+  };
+
+  Terminal.prototype.render = function() {
+    var follow = this.element.scrollTop + this.element.clientHeight >= this.element.scrollHeight - 4;
+    var html = [];
+    for (var y = 0; y < this.lines.length; y++) {
+      html.push(this.renderLine(this.lines[y], this.cursorVisible && y === this.y ? this.x : -1));
+    }
+    this.screenElement.innerHTML = html.join('\n');
+    if (follow) {
+      this.element.scrollTop = this.element.scrollHeight;
+    }
+  };
+
+  Terminal.prototype.keydown = function(event) {
+    // leave copy and paste shortcuts to the browser
+    if (event.metaKey || (event.ctrlKey && event.shiftKey)) {
+      return;
+    }
+
+    var data = null;
+    if (arrows[event.key]) {
+      data = (this.appCursor ? '\x1bO' : '\x1b[') + arrows[event.key];
+    } else if (keys[event.key]) {
+      data = keys[event.key];
+    } else if (event.key.length === 1) {
+      data = event.key;
+      if (event.ctrlKey) {
+        var code = event.key.toUpperCase().charCodeAt(0);
+        if (event.key === ' ') {
+          data = '\x00';
+        } else if (code >= 64 && code <= 95) {
+          data = String.fromCharCode(code - 64);
+        }
+      }
+      if (event.altKey) {
+        data = '\x1b' + data;
+      }
+    }
+    if (data === null) {
+      return;
+    }
+    event.preventDefault();
+    this.element.scrollTop = this.element.scrollHeight;
+    this.onData(data);
+  };
+
+  window.Terminal = Terminal;
+})();
@@ -0,0 +1,137 @@
+package templates
+
+import (
+	"embed"
+	htmltemplate "html/template"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	texttemplate "text/template"
+
+	"github.com/sirupsen/logrus"
+)
+
+// embedded holds the default templates and static assets. Files in the
+// override directory take precedence.
+//
+//go:embed index.html lab.html terminal.html setup.sh setup.ps1 session.sh session.ps1 session.txt ssh_config static
+var embedded embed.FS
+
+// funcs are available to all templates
+var funcs = map[string]interface{}{
+	"sh": shellQuote,
+	"ps": powerShellQuote,
+}
+
+// shellQuote quotes a value for POSIX shells
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'"'"'`, -1) + "'"
+}
+
+// powerShellQuote quotes a value for PowerShell
+func powerShellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", "''", -1) + "'"
+}
+
+type executor interface {
+	Execute(w io.Writer, data interface{}) error
+}
+
+// Templates parses templates once and caches them. With reload set they are
+// parsed on every use, so changes in the override directory show up without a
+// restart.
+type Templates struct {
+	log    *logrus.Entry
+	dir    string
+	reload bool
+
+	mutex sync.Mutex
+	cache map[string]executor
+}
+
+func New(log *logrus.Entry, dir string, reload bool) *Templates {
+	return &Templates{
+		log:    log,
+		dir:    dir,
+		reload: reload,
+		cache:  map[string]executor{},
+	}
+}
+
+// Execute renders the named template. Names ending in .html are HTML escaped,
+// everything else (setup scripts) is rendered as plain text.
+func (t *Templates) Execute(w io.Writer, name string, data interface{}) error {
+	tmpl, err := t.get(name)
+	if err != nil {
+		return err
+	}
+	return tmpl.Execute(w, data)
+}
+
+func (t *Templates) get(name string) (executor, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if tmpl, ok := t.cache[name]; ok && !t.reload {
+		return tmpl, nil
+	}
+
+	data, err := t.read(name)
+	if err != nil {
+		return nil, err
+	}
+
+	var tmpl executor
+	if strings.HasSuffix(name, ".html") {
+		tmpl, err = htmltemplate.New(name).Funcs(funcs).Parse(string(data))
+	} else {
+		tmpl, err = texttemplate.New(name).Funcs(funcs).Parse(string(data))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	t.cache[name] = tmpl
+	return tmpl, nil
+}
+
+// read returns the file from the override directory or the embedded defaults
+func (t *Templates) read(name string) ([]byte, error) {
+	name = path.Clean("/" + name)[1:]
+	if t.dir != "" {
+		data, err := ioutil.ReadFile(filepath.Join(t.dir, filepath.FromSlash(name)))
+		if err == nil {
+			t.log.Debugf("using %s from %s", name, t.dir)
+			return data, nil
+		}
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+	return embedded.ReadFile(name)
+}
+
+// Static serves the static assets, e.g. stylesheets and logos
+func (t *Templates) Static() http.Handler {
+	return http.FileServer(http.FS(staticFS{t}))
+}
+
+type staticFS struct {
+	t *Templates
+}
+
+func (s staticFS) Open(name string) (fs.File, error) {
+	if s.t.dir != "" {
+		f, err := os.Open(filepath.Join(s.t.dir, "static", filepath.FromSlash(path.Clean("/"+name))))
+		if err == nil {
+			return f, nil
+		}
+	}
+	return embedded.Open(path.Join("static", name))
+}
@@ -0,0 +1,34 @@
+package workers
+
+import (
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/mjudeikis/osa-labs/pkg/config"
+	"github.com/mjudeikis/osa-labs/pkg/store"
+)
+
+// Backend returns the worker manager of a lab whose workers run in namespace
+// and are recorded in storage
+type Backend func(log *logrus.Entry, storage store.Store, namespace string, cfg config.Workers) (Workers, error)
+
+var backends = map[string]Backend{}
+
+// Register makes a backend selectable by name in workers.backend. It is
+// called from init functions.
+func Register(name string, backend Backend) {
+	if _, ok := backends[name]; ok {
+		panic(fmt.Sprintf("worker backend %q registered twice", name))
+	}
+	backends[name] = backend
+}
+
+// New returns the worker manager of the configured backend
+func New(log *logrus.Entry, storage store.Store, namespace string, cfg config.Workers) (Workers, error) {
+	backend, ok := backends[cfg.Backend]
+	if !ok {
+		return nil, fmt.Errorf("unknown worker backend %q", cfg.Backend)
+	}
+	return backend(log.WithField("backend", cfg.Backend), storage, namespace, cfg)
+}
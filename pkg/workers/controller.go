@@ -0,0 +1,289 @@
+package workers
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/mjudeikis/osa-labs/pkg/api"
+	"github.com/mjudeikis/osa-labs/pkg/metrics"
+)
+
+const (
+	// resyncPeriod is how often all workers are synced even without changes
+	resyncPeriod = 10 * time.Minute
+	// maxSyncRetries is how often a failing worker sync is retried, with
+	// exponential backoff, before it waits for the next change
+	maxSyncRetries = 10
+)
+
+// poolEntry is a worker as last seen by the controller
+type poolEntry struct {
+	worker api.Worker
+	ready  bool
+}
+
+// pool is the worker inventory kept up to date by the controller
+type pool struct {
+	mutex   sync.Mutex
+	synced  bool
+	workers map[string]poolEntry
+	notify  chan struct{}
+}
+
+func newPool() *pool {
+	return &pool{
+		workers: map[string]poolEntry{},
+		notify:  make(chan struct{}),
+	}
+}
+
+// changed returns a channel which is closed on the next change
+func (p *pool) changed() <-chan struct{} {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.notify
+}
+
+func (p *pool) broadcast() {
+	close(p.notify)
+	p.notify = make(chan struct{})
+}
+
+func (p *pool) setSynced() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.synced = true
+	p.broadcast()
+}
+
+func (p *pool) isSynced() bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.synced
+}
+
+// set records a worker and returns whether it changed
+func (p *pool) set(name string, entry poolEntry) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if existing, ok := p.workers[name]; ok && reflect.DeepEqual(existing, entry) {
+		return false
+	}
+	p.workers[name] = entry
+	p.broadcast()
+	return true
+}
+
+// remove forgets a worker and returns whether it was known
+func (p *pool) remove(name string) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if _, ok := p.workers[name]; !ok {
+		return false
+	}
+	delete(p.workers, name)
+	p.broadcast()
+	return true
+}
+
+// entries returns the workers ordered by name
+func (p *pool) entries() []poolEntry {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	entries := make([]poolEntry, 0, len(p.workers))
+	for _, entry := range p.workers {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].worker.Name < entries[j].worker.Name })
+	return entries
+}
+
+// ready counts the workers with a ready deployment and with an endpoint
+func (p *pool) ready() (deployments, services int) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	for _, entry := range p.workers {
+		if entry.ready {
+			deployments++
+		}
+		if entry.worker.Host != "" {
+			services++
+		}
+	}
+	return deployments, services
+}
+
+// available counts the workers which are ready and reachable, zero until the
+// controller has synced
+func (p *pool) available() int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if !p.synced {
+		return 0
+	}
+	n := 0
+	for _, entry := range p.workers {
+		if entry.ready && entry.worker.Host != "" {
+			n++
+		}
+	}
+	return n
+}
+
+// enqueue queues the worker an object belongs to. The deployment, service
+// and secret of a worker share its name.
+func (k *kubeWorkers) enqueue(obj interface{}) {
+	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+	if err != nil {
+		k.log.Warnf("worker controller: %v", err)
+		return
+	}
+	_, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		k.log.Warnf("worker controller: %v", err)
+		return
+	}
+	k.queue.Add(name)
+}
+
+func (k *kubeWorkers) eventHandler() cache.ResourceEventHandler {
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc:    k.enqueue,
+		UpdateFunc: func(old, new interface{}) { k.enqueue(new) },
+		DeleteFunc: k.enqueue,
+	}
+}
+
+// Run watches the deployments, services and secrets of the namespace and
+// keeps the worker inventory in sync as they change, until the context is
+// done
+func (k *kubeWorkers) Run(ctx context.Context) {
+	defer k.queue.ShutDown()
+
+	k.factory.Start(ctx.Done())
+	if !cache.WaitForCacheSync(ctx.Done(), k.synced...) {
+		return
+	}
+
+	// fill the inventory before it is used, later changes come through the
+	// queue
+	deployments, err := k.dLister.List(labels.Everything())
+	if err != nil {
+		k.log.Errorf("worker controller: %v", err)
+		return
+	}
+	for _, dc := range deployments {
+		_, err := k.syncWorker(dc.GetName())
+		if err != nil {
+			k.log.Warnf("worker controller: syncing %s: %v", dc.GetName(), err)
+			k.queue.AddRateLimited(dc.GetName())
+		}
+	}
+	k.pool.setSynced()
+	k.snapshot()
+	k.log.Infof("worker controller synced %d workers", len(deployments))
+
+	go wait.Until(func() {
+		for k.processNextItem() {
+		}
+	}, time.Second, ctx.Done())
+	<-ctx.Done()
+}
+
+func (k *kubeWorkers) processNextItem() bool {
+	key, quit := k.queue.Get()
+	if quit {
+		return false
+	}
+	defer k.queue.Done(key)
+
+	changed, err := k.syncWorker(key.(string))
+	if err == nil && changed {
+		err = k.snapshot()
+	}
+	switch {
+	case err == nil:
+		k.queue.Forget(key)
+	case k.queue.NumRequeues(key) < maxSyncRetries:
+		k.log.Warnf("worker controller: syncing %s: %v", key, err)
+		k.queue.AddRateLimited(key)
+	default:
+		k.log.Errorf("worker controller: giving up on %s: %v", key, err)
+		k.queue.Forget(key)
+	}
+	return true
+}
+
+// syncWorker updates the inventory entry of a worker from the informer caches
+// and returns whether it changed
+func (k *kubeWorkers) syncWorker(name string) (bool, error) {
+	start := time.Now()
+	defer func() {
+		metrics.ReconcileDuration.WithLabelValues(k.namespace).Observe(time.Since(start).Seconds())
+	}()
+
+	dc, err := k.dLister.Get(name)
+	if kerrors.IsNotFound(err) {
+		return k.publish(k.pool.remove(name)), nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	wk := k.workerState(dc)
+	svc, err := k.svcLister.Get(name)
+	if err != nil && !kerrors.IsNotFound(err) {
+		return false, err
+	}
+	if err == nil {
+		k.setEndpoint(wk, svc)
+	}
+	secret, err := k.secretLister.Get(name)
+	if err != nil && !kerrors.IsNotFound(err) {
+		return false, err
+	}
+	if err == nil {
+		wk.SSHKey = string(secret.Data["id_rsa"])
+	}
+
+	return k.publish(k.pool.set(name, poolEntry{worker: *wk, ready: deploymentReady(dc)})), nil
+}
+
+// publish updates the readiness gauges after the inventory changed
+func (k *kubeWorkers) publish(changed bool) bool {
+	if changed {
+		deployments, services := k.pool.ready()
+		metrics.WorkersReady.WithLabelValues(k.namespace, "deployments").Set(float64(deployments))
+		metrics.WorkersReady.WithLabelValues(k.namespace, "services").Set(float64(services))
+	}
+	return changed
+}
+
+// waitReady waits until the controller has seen num workers ready and
+// reachable
+func (k *kubeWorkers) waitReady(ctx context.Context, num int) error {
+	for {
+		// grab the channel first so a change while counting is not missed
+		changed := k.pool.changed()
+		ready := k.pool.available()
+		if ready >= num {
+			k.log.Infof("%d workers ready", ready)
+			return nil
+		}
+		k.log.Debugf("%d of %d workers ready", ready, num)
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
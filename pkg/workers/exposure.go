@@ -0,0 +1,128 @@
+package workers
+
+import (
+	"net"
+	"strconv"
+
+	apiv1 "k8s.io/api/core/v1"
+
+	"github.com/mjudeikis/osa-labs/pkg/api"
+	"github.com/mjudeikis/osa-labs/pkg/config"
+)
+
+// exposure decides how worker services are published and where participants
+// reach the workers
+type exposure interface {
+	serviceType() apiv1.ServiceType
+	// endpoint returns where participants reach the worker behind svc. The
+	// host is empty while the worker is not reachable yet.
+	endpoint(svc *apiv1.Service) (string, int32)
+	// user returns the user participants log in to the worker as
+	user(name string) string
+}
+
+// workerUser is the user participants log in to workers as directly. Workers
+// accept any user holding the worker key.
+const workerUser = "lab"
+
+// newExposure returns the exposure of a validated configuration
+func newExposure(cfg config.Workers) exposure {
+	switch cfg.Exposure.Type {
+	case config.ExposureNodePort:
+		return &nodePortExposure{nodeAddress: cfg.Exposure.NodeAddress}
+	case config.ExposureGateway:
+		return &gatewayExposure{host: cfg.Exposure.GatewayHost, port: cfg.Exposure.GatewayPort}
+	}
+	return &loadBalancerExposure{port: cfg.Port}
+}
+
+// loadBalancerExposure reaches workers at the ingress of their load balancer.
+// Load balancers without an IP, e.g. on AWS, are reached at their hostname.
+type loadBalancerExposure struct {
+	port int32
+}
+
+var _ exposure = &loadBalancerExposure{}
+
+func (e *loadBalancerExposure) serviceType() apiv1.ServiceType {
+	return apiv1.ServiceTypeLoadBalancer
+}
+
+func (e *loadBalancerExposure) user(name string) string {
+	return workerUser
+}
+
+func (e *loadBalancerExposure) endpoint(svc *apiv1.Service) (string, int32) {
+	for _, ingress := range svc.Status.LoadBalancer.Ingress {
+		if ingress.IP != "" {
+			return ingress.IP, e.port
+		}
+	}
+	for _, ingress := range svc.Status.LoadBalancer.Ingress {
+		if ingress.Hostname != "" {
+			return ingress.Hostname, e.port
+		}
+	}
+	return "", 0
+}
+
+// nodePortExposure reaches workers at their node port on a configured node
+// address
+type nodePortExposure struct {
+	nodeAddress string
+}
+
+var _ exposure = &nodePortExposure{}
+
+func (e *nodePortExposure) serviceType() apiv1.ServiceType {
+	return apiv1.ServiceTypeNodePort
+}
+
+func (e *nodePortExposure) user(name string) string {
+	return workerUser
+}
+
+func (e *nodePortExposure) endpoint(svc *apiv1.Service) (string, int32) {
+	for _, port := range svc.Spec.Ports {
+		if port.Name == "ssh" && port.NodePort != 0 {
+			return e.nodeAddress, port.NodePort
+		}
+	}
+	return "", 0
+}
+
+// gatewayExposure keeps workers on cluster IPs. Participants connect to a
+// shared SSH gateway which forwards them to their worker.
+type gatewayExposure struct {
+	host string
+	port int32
+}
+
+var _ exposure = &gatewayExposure{}
+
+func (e *gatewayExposure) serviceType() apiv1.ServiceType {
+	return apiv1.ServiceTypeClusterIP
+}
+
+// user is the worker name, which the gateway routes by
+func (e *gatewayExposure) user(name string) string {
+	return name
+}
+
+func (e *gatewayExposure) endpoint(svc *apiv1.Service) (string, int32) {
+	if svc.Spec.ClusterIP == "" {
+		return "", 0
+	}
+	return e.host, e.port
+}
+
+// setEndpoint fills in where participants and the frontend reach the worker
+// behind svc
+func (k *kubeWorkers) setEndpoint(wk *api.Worker, svc *apiv1.Service) {
+	wk.Host, wk.Port = k.exposure.endpoint(svc)
+	wk.IP = wk.Host
+	wk.User = k.exposure.user(wk.Name)
+	if svc.Spec.ClusterIP != "" && svc.Spec.ClusterIP != apiv1.ClusterIPNone {
+		wk.Address = net.JoinHostPort(svc.Spec.ClusterIP, strconv.Itoa(int(k.config.Port)))
+	}
+}
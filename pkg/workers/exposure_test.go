@@ -0,0 +1,88 @@
+package workers
+
+import (
+	"testing"
+
+	apiv1 "k8s.io/api/core/v1"
+
+	"github.com/mjudeikis/osa-labs/pkg/api"
+	"github.com/mjudeikis/osa-labs/pkg/config"
+)
+
+func TestExposure(t *testing.T) {
+	ingress := func(ip, hostname string) *apiv1.Service {
+		svc := &apiv1.Service{Spec: apiv1.ServiceSpec{ClusterIP: "172.30.0.1"}}
+		svc.Status.LoadBalancer.Ingress = []apiv1.LoadBalancerIngress{{IP: ip, Hostname: hostname}}
+		return svc
+	}
+	nodePort := &apiv1.Service{Spec: apiv1.ServiceSpec{
+		ClusterIP: "172.30.0.1",
+		Ports:     []apiv1.ServicePort{{Name: "ssh", Port: 2222, NodePort: 30022}},
+	}}
+
+	for _, tt := range []struct {
+		name     string
+		exposure config.Exposure
+		svc      *apiv1.Service
+		wantHost string
+		wantPort int32
+		wantUser string
+	}{
+		{
+			name:     "load balancer IP",
+			exposure: config.Exposure{Type: config.ExposureLoadBalancer},
+			svc:      ingress("1.2.3.4", ""),
+			wantHost: "1.2.3.4",
+			wantPort: 2222,
+			wantUser: workerUser,
+		},
+		{
+			name:     "load balancer hostname",
+			exposure: config.Exposure{Type: config.ExposureLoadBalancer},
+			svc:      ingress("", "lb.example.com"),
+			wantHost: "lb.example.com",
+			wantPort: 2222,
+			wantUser: workerUser,
+		},
+		{
+			name:     "load balancer pending",
+			exposure: config.Exposure{Type: config.ExposureLoadBalancer},
+			svc:      &apiv1.Service{},
+			wantUser: workerUser,
+		},
+		{
+			name:     "node port",
+			exposure: config.Exposure{Type: config.ExposureNodePort, NodeAddress: "nodes.example.com"},
+			svc:      nodePort,
+			wantHost: "nodes.example.com",
+			wantPort: 30022,
+			wantUser: workerUser,
+		},
+		{
+			name:     "gateway",
+			exposure: config.Exposure{Type: config.ExposureGateway, GatewayHost: "ssh.example.com", GatewayPort: 22},
+			svc:      nodePort,
+			wantHost: "ssh.example.com",
+			wantPort: 22,
+			wantUser: "abcdefghij",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig()
+			cfg.Exposure = tt.exposure
+			k := &kubeWorkers{config: cfg, exposure: newExposure(cfg)}
+
+			wk := &api.Worker{Name: "abcdefghij"}
+			k.setEndpoint(wk, tt.svc)
+			if wk.Host != tt.wantHost || wk.Port != tt.wantPort || wk.User != tt.wantUser {
+				t.Errorf("expected %s@%s:%d, got %s@%s:%d", tt.wantUser, tt.wantHost, tt.wantPort, wk.User, wk.Host, wk.Port)
+			}
+			if wk.IP != wk.Host {
+				t.Errorf("expected IP %q to match the host", wk.IP)
+			}
+			if tt.svc.Spec.ClusterIP != "" && wk.Address != "172.30.0.1:2222" {
+				t.Errorf("unexpected address %q", wk.Address)
+			}
+		})
+	}
+}
@@ -0,0 +1,222 @@
+package workers
+
+import (
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	apiv1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/mjudeikis/osa-labs/pkg/metrics"
+)
+
+// the deployment, service and secret of a worker share its name and carry
+// the worker label
+const (
+	labelWorker = "worker"
+	valueWorker = "kube"
+)
+
+// gcGracePeriod keeps the garbage collector away from workers that are still
+// being created
+const gcGracePeriod = 5 * time.Minute
+
+func workerLabels() map[string]string {
+	return map[string]string{labelWorker: valueWorker}
+}
+
+func isWorker(meta metav1.ObjectMeta) bool {
+	return meta.Labels[labelWorker] == valueWorker
+}
+
+// workerResources are the worker objects in the namespace, by name
+type workerResources struct {
+	deployments map[string]*appsv1.Deployment
+	services    map[string]*apiv1.Service
+	secrets     map[string]*apiv1.Secret
+}
+
+// listResources finds the worker objects. Objects created before they were
+// labelled are recognised by their deployment.
+func (k *kubeWorkers) listResources() (*workerResources, error) {
+	deploymentList, err := k.dCli.List(metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	svcList, err := k.svcCli.List(metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	secretList, err := k.secretCli.List(metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	r := &workerResources{
+		deployments: map[string]*appsv1.Deployment{},
+		services:    map[string]*apiv1.Service{},
+		secrets:     map[string]*apiv1.Secret{},
+	}
+	for i := range deploymentList.Items {
+		dc := &deploymentList.Items[i]
+		if isWorker(dc.ObjectMeta) || dc.Spec.Template.Labels[labelWorker] == valueWorker {
+			r.deployments[dc.GetName()] = dc
+		}
+	}
+	for i := range svcList.Items {
+		svc := &svcList.Items[i]
+		if isWorker(svc.ObjectMeta) || r.deployments[svc.GetName()] != nil {
+			r.services[svc.GetName()] = svc
+		}
+	}
+	for i := range secretList.Items {
+		secret := &secretList.Items[i]
+		if isWorker(secret.ObjectMeta) || r.deployments[secret.GetName()] != nil && secret.Data["id_rsa"] != nil {
+			r.secrets[secret.GetName()] = secret
+		}
+	}
+	return r, nil
+}
+
+// GC repairs workers missing their service or secret and removes services and
+// secrets whose deployment is gone. Objects younger than gcGracePeriod are
+// left alone, they may belong to a worker being created.
+func (k *kubeWorkers) GC() error {
+	k.Lock()
+	defer k.Unlock()
+
+	r, err := k.listResources()
+	if err != nil {
+		return err
+	}
+	cutoff := time.Now().Add(-gcGracePeriod)
+	changed := false
+
+	for name, dc := range r.deployments {
+		if dc.GetCreationTimestamp().After(cutoff) {
+			continue
+		}
+		update := !isWorker(dc.ObjectMeta)
+		dc.SetLabels(mergeLabels(dc.GetLabels()))
+
+		if svc := r.services[name]; svc == nil {
+			k.log.Warnf("repairing worker %s: recreating its service", name)
+			_, err = k.svcCli.Create(workerService(name, k.config))
+			if err != nil {
+				return err
+			}
+			metrics.WorkerGC.WithLabelValues(k.namespace, "repaired").Inc()
+			changed = true
+		} else if !isWorker(svc.ObjectMeta) {
+			svc.SetLabels(mergeLabels(svc.GetLabels()))
+			_, err = k.svcCli.Update(svc)
+			if err != nil {
+				return err
+			}
+		}
+
+		if secret := r.secrets[name]; secret == nil {
+			// the old key is lost, the pod has to start over with a new one
+			k.log.Warnf("repairing worker %s: recreating its secret", name)
+			secret, err := workerSecret(name)
+			if err != nil {
+				return err
+			}
+			_, err = k.secretCli.Create(secret)
+			if err != nil {
+				return err
+			}
+			restartWorker(dc)
+			update = true
+			metrics.WorkerGC.WithLabelValues(k.namespace, "repaired").Inc()
+			changed = true
+		} else if !isWorker(secret.ObjectMeta) {
+			secret.SetLabels(mergeLabels(secret.GetLabels()))
+			_, err = k.secretCli.Update(secret)
+			if err != nil {
+				return err
+			}
+		}
+
+		if update {
+			_, err = k.dCli.Update(dc)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	for name, svc := range r.services {
+		if r.deployments[name] != nil || svc.GetCreationTimestamp().After(cutoff) {
+			continue
+		}
+		k.log.Warnf("removing orphaned service %s", name)
+		err = k.svcCli.Delete(name, &metav1.DeleteOptions{})
+		if err != nil && !kerrors.IsNotFound(err) {
+			return err
+		}
+		metrics.WorkerGC.WithLabelValues(k.namespace, "removed").Inc()
+		changed = true
+	}
+	for name, secret := range r.secrets {
+		if r.deployments[name] != nil || secret.GetCreationTimestamp().After(cutoff) {
+			continue
+		}
+		k.log.Warnf("removing orphaned secret %s", name)
+		err = k.secretCli.Delete(name, &metav1.DeleteOptions{})
+		if err != nil && !kerrors.IsNotFound(err) {
+			return err
+		}
+		metrics.WorkerGC.WithLabelValues(k.namespace, "removed").Inc()
+		changed = true
+	}
+
+	if changed {
+		k.snapshot()
+	}
+	return nil
+}
+
+// Teardown removes all workers, reserved or not, and anything left behind by
+// them
+func (k *kubeWorkers) Teardown() error {
+	k.Lock()
+	defer k.Unlock()
+
+	r, err := k.listResources()
+	if err != nil {
+		return err
+	}
+	for name := range r.deployments {
+		_, err = k.deleteWorker(name)
+		if err != nil {
+			return err
+		}
+		k.log.Infof("removed worker %s", name)
+	}
+	for name := range r.services {
+		err = k.svcCli.Delete(name, &metav1.DeleteOptions{})
+		if err != nil && !kerrors.IsNotFound(err) {
+			return err
+		}
+	}
+	for name := range r.secrets {
+		err = k.secretCli.Delete(name, &metav1.DeleteOptions{})
+		if err != nil && !kerrors.IsNotFound(err) {
+			return err
+		}
+	}
+
+	k.snapshot()
+	return nil
+}
+
+func mergeLabels(existing map[string]string) map[string]string {
+	merged := workerLabels()
+	for key, value := range existing {
+		merged[key] = value
+	}
+	merged[labelWorker] = valueWorker
+	return merged
+}
@@ -0,0 +1,98 @@
+package workers
+
+import (
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	apiv1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func testMeta(name string, labels map[string]string, created time.Time) metav1.ObjectMeta {
+	return metav1.ObjectMeta{
+		Name:              name,
+		Namespace:         testNamespace,
+		Labels:            labels,
+		CreationTimestamp: metav1.NewTime(created),
+	}
+}
+
+func TestGC(t *testing.T) {
+	old := time.Now().Add(-time.Hour)
+	objects := []runtime.Object{
+		// a complete worker
+		&appsv1.Deployment{ObjectMeta: testMeta("complete", workerLabels(), old)},
+		&apiv1.Service{ObjectMeta: testMeta("complete", workerLabels(), old)},
+		&apiv1.Secret{ObjectMeta: testMeta("complete", workerLabels(), old)},
+		// a worker which lost its secret
+		&appsv1.Deployment{ObjectMeta: testMeta("nosecret", workerLabels(), old)},
+		&apiv1.Service{ObjectMeta: testMeta("nosecret", workerLabels(), old)},
+		// leftovers of removed workers
+		&apiv1.Service{ObjectMeta: testMeta("orphan", workerLabels(), old)},
+		&apiv1.Secret{ObjectMeta: testMeta("orphan", workerLabels(), old)},
+		// a worker being created
+		&apiv1.Secret{ObjectMeta: testMeta("young", workerLabels(), time.Now())},
+		// not ours
+		&apiv1.Secret{ObjectMeta: testMeta("other", nil, old)},
+	}
+	k, cli := newTestKube(t, testConfig(), objects...)
+
+	err := k.GC()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	secrets := cli.CoreV1().Secrets(testNamespace)
+	services := cli.CoreV1().Services(testNamespace)
+
+	secret, err := secrets.Get("nosecret", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("expected the secret to be recreated: %v", err)
+	}
+	if len(secret.Data["id_rsa"]) == 0 {
+		t.Error("expected the recreated secret to hold a key")
+	}
+	dc, err := cli.AppsV1().Deployments(testNamespace).Get("nosecret", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dc.Spec.Template.GetAnnotations()[annotationRecycledAt] == "" {
+		t.Error("expected the worker with a new key to be restarted")
+	}
+
+	if _, err := services.Get("orphan", metav1.GetOptions{}); !kerrors.IsNotFound(err) {
+		t.Errorf("expected the orphaned service to be removed, got %v", err)
+	}
+	if _, err := secrets.Get("orphan", metav1.GetOptions{}); !kerrors.IsNotFound(err) {
+		t.Errorf("expected the orphaned secret to be removed, got %v", err)
+	}
+	for _, name := range []string{"complete", "young", "other"} {
+		if _, err := secrets.Get(name, metav1.GetOptions{}); err != nil {
+			t.Errorf("expected secret %s to be kept, got %v", name, err)
+		}
+	}
+}
+
+func TestTeardown(t *testing.T) {
+	k, cli := newTestKube(t, testConfig())
+	createWorkers(t, k, 2)
+
+	err := k.Teardown()
+	if err != nil {
+		t.Fatal(err)
+	}
+	deploymentList, err := cli.AppsV1().Deployments(testNamespace).List(metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	svcList, err := cli.CoreV1().Services(testNamespace).List(metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deploymentList.Items) != 0 || len(svcList.Items) != 0 {
+		t.Errorf("expected all workers to be removed, got %d deployments, %d services", len(deploymentList.Items), len(svcList.Items))
+	}
+}
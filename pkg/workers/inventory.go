@@ -0,0 +1,182 @@
+package workers
+
+import (
+	"os"
+	"sort"
+	"time"
+
+	"github.com/ghodss/yaml"
+	"github.com/sirupsen/logrus"
+
+	"github.com/mjudeikis/osa-labs/pkg/api"
+	"github.com/mjudeikis/osa-labs/pkg/store"
+)
+
+// inventory keeps the reservation state of workers in memory, for backends
+// with no place of their own to keep it. Callers hold the backend lock.
+type inventory struct {
+	entries []*entry
+}
+
+type entry struct {
+	api.Worker
+	// idleSince is when the worker was last released, or added
+	idleSince time.Time
+}
+
+// worker returns a copy of the worker which does not share its lease
+func (e *entry) worker() api.Worker {
+	wk := e.Worker
+	wk.Lease = copyLease(wk.Lease)
+	return wk
+}
+
+func copyLease(lease *api.Lease) *api.Lease {
+	if lease == nil {
+		return nil
+	}
+	copied := *lease
+	return &copied
+}
+
+func (inv *inventory) find(name string) *entry {
+	for _, e := range inv.entries {
+		if e.Name == name {
+			return e
+		}
+	}
+	return nil
+}
+
+func (inv *inventory) add(wk api.Worker) {
+	inv.entries = append(inv.entries, &entry{Worker: wk, idleSince: time.Now()})
+}
+
+func (inv *inventory) remove(name string) {
+	for i, e := range inv.entries {
+		if e.Name == name {
+			inv.entries = append(inv.entries[:i], inv.entries[i+1:]...)
+			return
+		}
+	}
+}
+
+// get returns the worker already leased to lease.Claim, or reserves the first
+// free worker ready returns true for, leaving ahead free workers for the
+// participants queued in front. It returns nil if no worker is available.
+func (inv *inventory) get(lease *api.Lease, ahead int, ready func(*entry) bool) *api.Worker {
+	// repeated request from the same participant gets the same worker
+	for _, e := range inv.entries {
+		if e.Reserved && e.Lease != nil && e.Lease.Claim == lease.Claim {
+			wk := e.worker()
+			return &wk
+		}
+	}
+
+	for _, e := range inv.entries {
+		if e.Reserved || !ready(e) {
+			continue
+		}
+		if ahead > 0 {
+			ahead--
+			continue
+		}
+		e.Reserved = true
+		e.Lease = copyLease(lease)
+		// a newly reserved worker carries the given lease
+		wk := e.Worker
+		wk.Lease = lease
+		return &wk
+	}
+	return nil
+}
+
+// update applies fn to the reservation state and metadata of the named
+// worker. It returns false if there is no such worker, and whether the worker
+// was released.
+func (inv *inventory) update(name string, fn func(*api.Worker)) (found, released bool) {
+	e := inv.find(name)
+	if e == nil {
+		return false, false
+	}
+	wk := e.worker()
+	fn(&wk)
+	released = e.Reserved && !wk.Reserved
+	e.Reserved, e.Lease, e.Metadata = wk.Reserved, copyLease(wk.Lease), wk.Metadata
+	if released {
+		e.idleSince = time.Now()
+	}
+	return true, released
+}
+
+// list returns the workers sorted by name
+func (inv *inventory) list() []api.Worker {
+	workers := make([]api.Worker, 0, len(inv.entries))
+	for _, e := range inv.entries {
+		workers = append(workers, e.worker())
+	}
+	sort.Slice(workers, func(i, j int) bool { return workers[i].Name < workers[j].Name })
+	return workers
+}
+
+func (inv *inventory) reserved() int {
+	reserved := 0
+	for _, e := range inv.entries {
+		if e.Reserved {
+			reserved++
+		}
+	}
+	return reserved
+}
+
+// idle returns the free workers idle since before cutoff, longest idle first
+func (inv *inventory) idle(cutoff time.Time) []*entry {
+	var idle []*entry
+	for _, e := range inv.entries {
+		if !e.Reserved && e.idleSince.Before(cutoff) {
+			idle = append(idle, e)
+		}
+	}
+	sort.SliceStable(idle, func(i, j int) bool { return idle[i].idleSince.Before(idle[j].idleSince) })
+	return idle
+}
+
+// save records the workers in the store
+func (inv *inventory) save(storage store.Store) error {
+	data, err := yaml.Marshal(api.WorkersStore{Workers: inv.list()})
+	if err != nil {
+		return err
+	}
+	return storage.Put("workers", data)
+}
+
+// snapshot records the workers in the store for export, logging failures
+func (inv *inventory) snapshot(log *logrus.Entry, storage store.Store) {
+	err := inv.save(storage)
+	if err != nil {
+		log.Warnf("saving workers snapshot: %v", err)
+	}
+}
+
+// restore applies the reservations recorded in the store to the workers of
+// the same name
+func (inv *inventory) restore(storage store.Store) error {
+	data, err := storage.Get("workers")
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var previous api.WorkersStore
+	err = yaml.Unmarshal(data, &previous)
+	if err != nil {
+		return err
+	}
+	for _, wk := range previous.Workers {
+		if e := inv.find(wk.Name); e != nil {
+			e.Reserved, e.Lease, e.Metadata = wk.Reserved, wk.Lease, wk.Metadata
+		}
+	}
+	return nil
+}
@@ -4,199 +4,728 @@ import (
 	"context"
 	"crypto/rand"
 	"crypto/rsa"
+	"encoding/json"
 	"os"
+	"sort"
+	"strconv"
 	"sync"
 	"time"
 
+	"github.com/ghodss/yaml"
 	"github.com/sirupsen/logrus"
-	"gopkg.in/yaml.v2"
 	appsv1 "k8s.io/api/apps/v1"
 	apiv1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
 	appsv1client "k8s.io/client-go/kubernetes/typed/apps/v1"
 	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+	appsv1listers "k8s.io/client-go/listers/apps/v1"
+	corev1listers "k8s.io/client-go/listers/core/v1"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/client-go/util/workqueue"
 
 	"github.com/mjudeikis/osa-labs/pkg/api"
+	"github.com/mjudeikis/osa-labs/pkg/config"
+	"github.com/mjudeikis/osa-labs/pkg/metrics"
 	"github.com/mjudeikis/osa-labs/pkg/store"
 	"github.com/mjudeikis/osa-labs/pkg/utils/keygen"
 	"github.com/mjudeikis/osa-labs/pkg/utils/random"
-	"github.com/mjudeikis/osa-labs/pkg/utils/wait"
 )
 
-const workersNamespace = "workers"
-
 type kubeWorkers struct {
 	client kubernetes.Interface
 	sync.Mutex
-	log    *logrus.Entry
-	image  string
-	number int
-	store  store.Store
+	log       *logrus.Entry
+	namespace string
+	config    config.Workers
+	store     store.Store
 
 	dCli      appsv1client.DeploymentInterface
 	svcCli    corev1client.ServiceInterface
 	secretCli corev1client.SecretInterface
+
+	// the controller keeps pool in sync with the informer caches
+	factory      informers.SharedInformerFactory
+	dLister      appsv1listers.DeploymentNamespaceLister
+	svcLister    corev1listers.ServiceNamespaceLister
+	secretLister corev1listers.SecretNamespaceLister
+	synced       []cache.InformerSynced
+	queue        workqueue.RateLimitingInterface
+	pool         *pool
+
+	exposure exposure
 }
 
 var _ Workers = &kubeWorkers{}
 
-func (k kubeWorkers) Get() (*api.Worker, error) {
+// reservation state is kept on the worker deployments so it survives restarts
+// and is shared by all frontend replicas
+const (
+	labelReserved      = "osa-labs/reserved"
+	annotationLease    = "osa-labs/lease"
+	annotationMetadata = "osa-labs/metadata"
+	// annotationIdleSince records when a worker was last released
+	annotationIdleSince = "osa-labs/idle-since"
+	// annotationRecycledAt on the pod template restarts the worker pod
+	annotationRecycledAt = "osa-labs/recycled-at"
+)
 
-	return nil, nil
-}
+// Get picks the worker from the inventory kept by the controller and only
+// goes to the API to record a new reservation
+func (k *kubeWorkers) Get(lease *api.Lease, ahead int) (*api.Worker, error) {
+	k.Lock()
+	defer k.Unlock()
 
-func (k kubeWorkers) Create() error {
-	deploymentList, err := k.dCli.List(metav1.ListOptions{})
+	entries, err := k.entries()
 	if err != nil {
-		return err
+		return nil, err
 	}
-	n := k.number - len(deploymentList.Items)
-	k.log.Infof("create workers %v", n)
-	if n > 0 {
-		for i := 0; i < n; i++ {
-			name, err := k.createWorker()
-			if err != nil {
-				return err
-			}
-			k.log.Println(name)
+
+	// repeated request from the same participant gets the same worker
+	for _, entry := range entries {
+		wk := entry.worker
+		if wk.Reserved && wk.Lease != nil && wk.Lease.Claim == lease.Claim {
+			current := *wk.Lease
+			wk.Lease = &current
+			return &wk, nil
 		}
 	}
 
-	return k.reconcileWorkers(context.Background(), k.number )
+	for _, entry := range entries {
+		if entry.worker.Reserved || !entry.ready || entry.worker.Host == "" {
+			continue
+		}
+		if ahead > 0 {
+			ahead--
+			continue
+		}
+
+		wk, err := k.reserve(entry.worker, lease)
+		if err != nil {
+			return nil, err
+		}
+		if wk == nil {
+			continue
+		}
+		k.snapshot()
+		return wk, nil
+	}
+	return nil, nil
 }
 
-func New(log *logrus.Entry, image string, number int) (Workers, error) {
-	config, err := getConfig()
+// reserve records the lease on the deployment of a free worker. It returns
+// nil if the worker changed since it was seen, e.g. it was reserved by another
+// replica.
+func (k *kubeWorkers) reserve(wk api.Worker, lease *api.Lease) (*api.Worker, error) {
+	dc, err := k.deployment(wk.Name)
+	if kerrors.IsNotFound(err) {
+		return nil, nil
+	}
 	if err != nil {
 		return nil, err
 	}
-	cli, err := kubernetes.NewForConfig(config)
+	if k.workerState(dc).Reserved || !deploymentReady(dc) {
+		return nil, nil
+	}
+
+	wk.Reserved = true
+	wk.Lease = lease
+	err = setWorkerState(dc, &wk)
 	if err != nil {
 		return nil, err
 	}
-	storage, err := store.New(log, "storage", "credentials")
+	dc, err = k.dCli.Update(dc)
+	if kerrors.IsConflict(err) {
+		k.log.Debugf("worker %s changed while reserving, skipping", wk.Name)
+		return nil, nil
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	return &kubeWorkers{
-		log:       log,
-		client:    cli,
-		image:     image,
-		number:    number,
-		store:     storage,
-		dCli:      cli.AppsV1().Deployments(workersNamespace),
-		svcCli:    cli.CoreV1().Services(workersNamespace),
-		secretCli: cli.CoreV1().Secrets(workersNamespace),
-	}, nil
+	// the inventory catches up with the informer event, until then repeated
+	// requests of the participant must find the reservation
+	stored := wk
+	stored.Lease = k.workerState(dc).Lease
+	k.pool.set(wk.Name, poolEntry{worker: stored, ready: deploymentReady(dc)})
+	return &wk, nil
 }
 
-func (k kubeWorkers) reconcileWorkers(ctx context.Context, num int) error {
+// entries returns the inventory kept by the controller, or lists the workers
+// through the API if the controller is not running, e.g. for CLI commands
+func (k *kubeWorkers) entries() ([]poolEntry, error) {
+	if k.pool.isSynced() {
+		return k.pool.entries(), nil
+	}
 
-	err := wait.PollImmediateUntil(10*time.Second, func() (bool, error) {
-		ready := 0
-		deploymentList, err := k.dCli.List(metav1.ListOptions{})
+	deploymentList, err := k.dCli.List(metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	svcList, err := k.svcCli.List(metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	secretList, err := k.secretCli.List(metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	services := map[string]*apiv1.Service{}
+	for i := range svcList.Items {
+		services[svcList.Items[i].GetName()] = &svcList.Items[i]
+	}
+	keys := map[string]string{}
+	for _, secret := range secretList.Items {
+		keys[secret.GetName()] = string(secret.Data["id_rsa"])
+	}
+
+	entries := []poolEntry{}
+	for i := range deploymentList.Items {
+		dc := &deploymentList.Items[i]
+		wk := k.workerState(dc)
+		if svc := services[wk.Name]; svc != nil {
+			k.setEndpoint(wk, svc)
+		}
+		wk.SSHKey = keys[wk.Name]
+		entries = append(entries, poolEntry{worker: *wk, ready: deploymentReady(dc)})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].worker.Name < entries[j].worker.Name })
+	return entries, nil
+}
+
+// deployment returns the deployment of a worker from the informer cache, or
+// from the API if the controller is not running
+func (k *kubeWorkers) deployment(name string) (*appsv1.Deployment, error) {
+	if k.pool.isSynced() {
+		dc, err := k.dLister.Get(name)
 		if err != nil {
-			k.log.Error(err)
-			return false, nil
+			return nil, err
 		}
-		for _, dc := range deploymentList.Items {
-			if dc.Status.Replicas == dc.Status.ReadyReplicas {
-				ready++
-				k.log.Infof("deployment count: %v . Ready %v. Expected: %v", len(deploymentList.Items), ready, num)
-			}
+		return dc.DeepCopy(), nil
+	}
+	return k.dCli.Get(name, metav1.GetOptions{})
+}
+
+// List returns the inventory kept by the controller, or lists the workers
+// through the API if the controller is not running, e.g. for CLI commands
+func (k *kubeWorkers) List() ([]api.Worker, error) {
+	entries, err := k.entries()
+	if err != nil {
+		return nil, err
+	}
+	workers := make([]api.Worker, 0, len(entries))
+	for _, entry := range entries {
+		workers = append(workers, entry.worker)
+	}
+	return workers, nil
+}
+
+func (k *kubeWorkers) Update(name string, fn func(*api.Worker)) (*api.Worker, error) {
+	k.Lock()
+	defer k.Unlock()
+
+	var result *api.Worker
+	var recycled, rotated bool
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		result = nil
+		dc, err := k.dCli.Get(name, metav1.GetOptions{})
+		if kerrors.IsNotFound(err) {
+			return nil
 		}
-		if ready >= num {
-			k.log.Info("all deployment ready")
-			return true, nil
+		if err != nil {
+			return err
 		}
-		k.log.Debug("reconcile workers")
-		return false, nil
-	}, ctx.Done())
 
-	err = wait.PollImmediateUntil(10*time.Second, func() (bool, error) {
-		ready := 0
-		svcList, err := k.svcCli.List(metav1.ListOptions{})
+		wk := k.workerState(dc)
+		wasReserved := wk.Reserved
+		fn(wk)
+		err = setWorkerState(dc, wk)
 		if err != nil {
-			k.log.Error(err)
-			return false, nil
+			return err
 		}
-		for _, svc := range svcList.Items {
-			if len(svc.Status.LoadBalancer.Ingress) >= 1 {
-				ready++
-				k.log.Infof("svc count: %v . Ready %v. Expected: %v", len(svcList.Items), ready, num)
+		// released workers are recycled before the next participant gets
+		// them. The server only releases for the holder, admins and expired
+		// leases, as this ends the holder's session.
+		recycled = wasReserved && !wk.Reserved
+		if recycled {
+			if !rotated {
+				err = k.rotateKey(name)
+				if err != nil {
+					return err
+				}
+				rotated = true
 			}
+			restartWorker(dc)
 		}
-		if ready >= num {
-			k.log.Info("all services ready")
-			return true, nil
+		_, err = k.dCli.Update(dc)
+		if err != nil {
+			return err
 		}
-		k.log.Debug("reconcile IP's")
+		result = wk
+		return nil
+	})
+	if err != nil || result == nil {
+		return nil, err
+	}
+	if recycled {
+		k.log.Infof("recycling released worker %s", name)
+		metrics.WorkersRecycled.WithLabelValues(k.namespace).Inc()
+	}
+
+	k.snapshot()
+	return result, k.fill(result)
+}
+
+func (k *kubeWorkers) Delete(name string) (bool, error) {
+	k.Lock()
+	defer k.Unlock()
+
+	found, err := k.deleteWorker(name)
+	if found {
+		k.snapshot()
+	}
+	return found, err
+}
+
+// Recycle resets the named worker: its SSH key pair is rotated and its pod is
+// replaced, so nothing of the previous participant is left. The worker is not
+// handed out until the new pod is ready. It returns false if there is no such
+// worker.
+func (k *kubeWorkers) Recycle(name string) (bool, error) {
+	k.Lock()
+	defer k.Unlock()
+
+	_, err := k.dCli.Get(name, metav1.GetOptions{})
+	if kerrors.IsNotFound(err) {
 		return false, nil
-	}, ctx.Done())
+	}
+	if err != nil {
+		return false, err
+	}
+
+	err = k.rotateKey(name)
+	if err != nil {
+		return true, err
+	}
+	err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		dc, err := k.dCli.Get(name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		restartWorker(dc)
+		_, err = k.dCli.Update(dc)
+		return err
+	})
+	if err != nil {
+		return true, err
+	}
+
+	k.log.Infof("recycling worker %s", name)
+	metrics.WorkersRecycled.WithLabelValues(k.namespace).Inc()
+	k.snapshot()
+	return true, nil
+}
+
+// rotateKey replaces the SSH key pair of a worker. The pod picks it up when it
+// is restarted.
+func (k *kubeWorkers) rotateKey(name string) error {
+	data, err := newSSHKey()
+	if err != nil {
+		return err
+	}
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		secret, err := k.secretCli.Get(name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		secret.Data = data
+		_, err = k.secretCli.Update(secret)
+		return err
+	})
+}
+
+// restartWorker changes the pod template so the deployment replaces the pod
+func restartWorker(dc *appsv1.Deployment) {
+	if dc.Spec.Template.Annotations == nil {
+		dc.Spec.Template.Annotations = map[string]string{}
+	}
+	dc.Spec.Template.Annotations[annotationRecycledAt] = time.Now().UTC().Format(time.RFC3339Nano)
+}
+
+// Scale keeps config.Spares free workers on top of the reserved ones, within
+// config.Number and config.Max workers. Free workers above that are removed
+// once they have been idle for config.ScaleDownDelay, broken ones first.
+func (k *kubeWorkers) Scale() error {
+	k.Lock()
+	defer k.Unlock()
 
-	// populate database
-	var workerStore api.WorkersStore
 	deploymentList, err := k.dCli.List(metav1.ListOptions{})
 	if err != nil {
 		return err
 	}
+
+	reserved := 0
+	var idle []appsv1.Deployment
+	cutoff := time.Now().Add(-k.config.ScaleDownDelay.Duration)
 	for _, dc := range deploymentList.Items {
-		k.log.Debug(dc.GetName())
-		secret, err := k.secretCli.Get(dc.GetName(), metav1.GetOptions{})
+		if k.workerState(&dc).Reserved {
+			reserved++
+			continue
+		}
+		if idleSince(&dc).Before(cutoff) {
+			idle = append(idle, dc)
+		}
+	}
+
+	desired := desiredWorkers(k.config, reserved)
+	metrics.WorkersDesired.WithLabelValues(k.namespace).Set(float64(desired))
+
+	current := len(deploymentList.Items)
+	switch {
+	case current < desired:
+		k.log.Infof("scaling workers up from %d to %d, %d reserved", current, desired, reserved)
+		for i := current; i < desired; i++ {
+			name, err := k.createWorker()
+			if err != nil {
+				return err
+			}
+			k.log.Infof("created worker %s", name)
+		}
+
+	case current > desired && len(idle) > 0:
+		sort.SliceStable(idle, func(i, j int) bool {
+			if deploymentReady(&idle[i]) != deploymentReady(&idle[j]) {
+				return !deploymentReady(&idle[i])
+			}
+			return idleSince(&idle[i]).Before(idleSince(&idle[j]))
+		})
+		n := current - desired
+		if n > len(idle) {
+			n = len(idle)
+		}
+		k.log.Infof("scaling workers down from %d to %d, %d reserved", current, current-n, reserved)
+		for _, dc := range idle[:n] {
+			_, err := k.deleteWorker(dc.GetName())
+			if err != nil {
+				return err
+			}
+			k.log.Infof("removed idle worker %s", dc.GetName())
+		}
+
+	default:
+		return nil
+	}
+
+	k.snapshot()
+	return nil
+}
+
+// desiredWorkers is the pool size needed to keep the spares on top of the
+// reserved workers
+func desiredWorkers(cfg config.Workers, reserved int) int {
+	desired := reserved + cfg.Spares
+	if desired < cfg.Number {
+		desired = cfg.Number
+	}
+	if desired > cfg.Max {
+		desired = cfg.Max
+	}
+	return desired
+}
+
+// idleSince returns when the worker was last released, or created if it never
+// was
+func idleSince(dc *appsv1.Deployment) time.Time {
+	if t, err := time.Parse(time.RFC3339, dc.GetAnnotations()[annotationIdleSince]); err == nil {
+		return t
+	}
+	return dc.GetCreationTimestamp().Time
+}
+
+// deleteWorker removes the deployment, service and secret of a worker
+func (k *kubeWorkers) deleteWorker(name string) (bool, error) {
+	err := k.dCli.Delete(name, &metav1.DeleteOptions{})
+	if kerrors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return true, err
+	}
+	err = k.svcCli.Delete(name, &metav1.DeleteOptions{})
+	if err != nil && !kerrors.IsNotFound(err) {
+		return true, err
+	}
+	err = k.secretCli.Delete(name, &metav1.DeleteOptions{})
+	if err != nil && !kerrors.IsNotFound(err) {
+		return true, err
+	}
+	return true, nil
+}
+
+// workerState reads the reservation state of a worker from its deployment
+func (k *kubeWorkers) workerState(dc *appsv1.Deployment) *api.Worker {
+	wk := &api.Worker{
+		Name:     dc.GetName(),
+		Reserved: dc.GetLabels()[labelReserved] == "true",
+		Metadata: dc.GetAnnotations()[annotationMetadata],
+	}
+	if data := dc.GetAnnotations()[annotationLease]; data != "" {
+		var lease api.Lease
+		if err := json.Unmarshal([]byte(data), &lease); err != nil {
+			k.log.Warnf("worker %s has an invalid lease: %v", wk.Name, err)
+		} else {
+			wk.Lease = &lease
+		}
+	}
+	return wk
+}
+
+// setWorkerState writes the reservation state of a worker to its deployment
+func setWorkerState(dc *appsv1.Deployment, wk *api.Worker) error {
+	labels := dc.GetLabels()
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	wasReserved := labels[labelReserved] == "true"
+	labels[labelReserved] = strconv.FormatBool(wk.Reserved)
+	dc.SetLabels(labels)
+
+	annotations := dc.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	if wasReserved && !wk.Reserved {
+		annotations[annotationIdleSince] = time.Now().UTC().Format(time.RFC3339)
+	}
+	delete(annotations, annotationLease)
+	if wk.Lease != nil {
+		data, err := json.Marshal(wk.Lease)
 		if err != nil {
 			return err
 		}
-		sshKey := string(secret.Data["id_rsa"][:])
+		annotations[annotationLease] = string(data)
+	}
+	delete(annotations, annotationMetadata)
+	if wk.Metadata != "" {
+		annotations[annotationMetadata] = wk.Metadata
+	}
+	dc.SetAnnotations(annotations)
+	return nil
+}
+
+// fill looks up the endpoint and SSH key of a worker
+func (k *kubeWorkers) fill(wk *api.Worker) error {
+	svc, err := k.svcCli.Get(wk.Name, metav1.GetOptions{})
+	if err != nil && !kerrors.IsNotFound(err) {
+		return err
+	}
+	if err == nil {
+		k.setEndpoint(wk, svc)
+	}
+
+	secret, err := k.secretCli.Get(wk.Name, metav1.GetOptions{})
+	if err != nil && !kerrors.IsNotFound(err) {
+		return err
+	}
+	if err == nil {
+		wk.SSHKey = string(secret.Data["id_rsa"])
+	}
+	return nil
+}
 
-		svc, err := k.svcCli.Get(dc.GetName(), metav1.GetOptions{})
+// snapshot records the workers in the store, so they can be exported without
+// access to the cluster
+func (k *kubeWorkers) snapshot() error {
+	workers, err := k.List()
+	if err == nil {
+		var data []byte
+		data, err = yaml.Marshal(api.WorkersStore{Workers: workers})
+		if err == nil {
+			err = k.store.Put("workers", data)
+		}
+	}
+	if err != nil {
+		k.log.Warnf("saving workers snapshot: %v", err)
+	}
+	return err
+}
+
+// deploymentReady returns true once the current pod template is rolled out
+// and ready, so a recycled worker is not handed out while it restarts
+func deploymentReady(dc *appsv1.Deployment) bool {
+	return dc.Status.ObservedGeneration >= dc.GetGeneration() &&
+		dc.Status.UpdatedReplicas == dc.Status.Replicas &&
+		dc.Status.ReadyReplicas > 0 && dc.Status.ReadyReplicas == dc.Status.Replicas
+}
+
+func (k *kubeWorkers) Create(ctx context.Context) error {
+	// repair workers left incomplete by a previous run before counting them
+	err := k.GC()
+	if err != nil {
+		return err
+	}
+
+	k.Lock()
+	deploymentList, err := k.dCli.List(metav1.ListOptions{})
+	if err != nil {
+		k.Unlock()
+		return err
+	}
+	n := k.config.Number - len(deploymentList.Items)
+	k.log.Infof("create workers %v", n)
+	for i := 0; i < n; i++ {
+		name, err := k.createWorker()
 		if err != nil {
+			k.Unlock()
 			return err
 		}
-		ip := svc.Status.LoadBalancer.Ingress[0].IP
+		k.log.Println(name)
+	}
+	k.Unlock()
 
-		workerStore.Workers = append(workerStore.Workers, api.Worker{
-			Name:     dc.GetName(),
-			IP:       ip,
-			Reserved: false,
-			SSHKey:   sshKey,
-		})
+	err = k.waitReady(ctx, k.config.Number)
+	if err != nil {
+		return err
 	}
+	err = k.adoptReservations()
+	if err != nil {
+		return err
+	}
+	return k.snapshot()
+}
 
-	bytes, err := yaml.Marshal(workerStore)
+func init() {
+	Register(config.BackendKube, newKube)
+}
+
+// newKube returns a worker manager running workers as deployments in
+// namespace and recording them in storage
+func newKube(log *logrus.Entry, storage store.Store, namespace string, cfg config.Workers) (Workers, error) {
+	restConfig, err := getConfig()
+	if err != nil {
+		return nil, err
+	}
+	cli, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return nil, err
+	}
+	return newKubeWorkers(log, storage, namespace, cfg, cli), nil
+}
+
+func newKubeWorkers(log *logrus.Entry, storage store.Store, namespace string, cfg config.Workers, cli kubernetes.Interface) *kubeWorkers {
+	factory := informers.NewFilteredSharedInformerFactory(cli, resyncPeriod, namespace, nil)
+	deployments := factory.Apps().V1().Deployments()
+	services := factory.Core().V1().Services()
+	secrets := factory.Core().V1().Secrets()
+
+	k := &kubeWorkers{
+		log:          log,
+		client:       cli,
+		namespace:    namespace,
+		config:       cfg,
+		store:        storage,
+		dCli:         cli.AppsV1().Deployments(namespace),
+		svcCli:       cli.CoreV1().Services(namespace),
+		secretCli:    cli.CoreV1().Secrets(namespace),
+		factory:      factory,
+		dLister:      deployments.Lister().Deployments(namespace),
+		svcLister:    services.Lister().Services(namespace),
+		secretLister: secrets.Lister().Secrets(namespace),
+		synced: []cache.InformerSynced{
+			deployments.Informer().HasSynced,
+			services.Informer().HasSynced,
+			secrets.Informer().HasSynced,
+		},
+		queue: workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "workers-"+namespace),
+		pool:  newPool(),
+
+		exposure: newExposure(cfg),
+	}
+	deployments.Informer().AddEventHandler(k.eventHandler())
+	services.Informer().AddEventHandler(k.eventHandler())
+	secrets.Informer().AddEventHandler(k.eventHandler())
+	return k
+}
+
+// adoptReservations moves reservations recorded in the store by earlier
+// versions onto the worker deployments that do not carry any state yet
+func (k *kubeWorkers) adoptReservations() error {
+	data, err := k.store.Get("workers")
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var previous api.WorkersStore
+	err = yaml.Unmarshal(data, &previous)
 	if err != nil {
 		return err
 	}
 
-	return k.store.Put("workers", bytes)
+	k.Lock()
+	defer k.Unlock()
+	for _, wk := range previous.Workers {
+		if !wk.Reserved && wk.Metadata == "" {
+			continue
+		}
+		dc, err := k.dCli.Get(wk.Name, metav1.GetOptions{})
+		if kerrors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if _, ok := dc.GetLabels()[labelReserved]; ok {
+			continue
+		}
+		k.log.Infof("adopting reservation of worker %s from the store", wk.Name)
+		wk := wk
+		err = setWorkerState(dc, &wk)
+		if err != nil {
+			return err
+		}
+		_, err = k.dCli.Update(dc)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-func (k kubeWorkers) createWorker() (string, error) {
-	template, err := getWorkerTemplate(k.image)
+func (k *kubeWorkers) createWorker() (string, error) {
+	template, err := getWorkerTemplate(k.config)
 	if err != nil {
 		return "", err
 	}
 
+	name := template["deployment"].(*appsv1.Deployment).GetName()
 	_, err = k.dCli.Create(template["deployment"].(*appsv1.Deployment))
 	if err != nil {
 		return "", err
 	}
 	_, err = k.svcCli.Create(template["service"].(*apiv1.Service))
-	if err != nil {
-		return "", err
+	if err == nil {
+		_, err = k.secretCli.Create(template["secret"].(*apiv1.Secret))
 	}
-	_, err = k.secretCli.Create(template["secret"].(*apiv1.Secret))
 	if err != nil {
+		// do not leave an incomplete worker behind
+		if _, derr := k.deleteWorker(name); derr != nil {
+			k.log.Errorf("removing incomplete worker %s: %v", name, derr)
+		}
 		return "", err
 	}
 
-	return template["deployment"].(*appsv1.Deployment).GetName(), nil
+	return name, nil
 }
 
-func getWorkerTemplate(image string) (map[string]interface{}, error) {
+func getWorkerTemplate(cfg config.Workers) (map[string]interface{}, error) {
 	name, err := random.LowerCaseAlphaString(10)
 	if err != nil {
 		return nil, err
@@ -207,9 +736,17 @@ func getWorkerTemplate(image string) (map[string]interface{}, error) {
 	dt := &appsv1.Deployment{
 		ObjectMeta: metav1.ObjectMeta{
 			Name: name,
+			Labels: map[string]string{
+				labelWorker:   valueWorker,
+				labelReserved: "false",
+			},
 		},
 		Spec: appsv1.DeploymentSpec{
 			Replicas: int32Ptr(1),
+			// the old pod is gone before a recycled worker is ready again
+			Strategy: appsv1.DeploymentStrategy{
+				Type: appsv1.RecreateDeploymentStrategyType,
+			},
 			Selector: &metav1.LabelSelector{
 				MatchLabels: map[string]string{
 					"worker": "kube",
@@ -227,13 +764,15 @@ func getWorkerTemplate(image string) (map[string]interface{}, error) {
 					Containers: []apiv1.Container{
 						{
 							Name:            "worker",
-							Image:           image,
-							ImagePullPolicy: apiv1.PullAlways,
+							Image:           cfg.Image,
+							ImagePullPolicy: apiv1.PullPolicy(cfg.ImagePullPolicy),
+							// cmd/ssh listens on the configured port
+							Args: []string{"-port", strconv.Itoa(int(cfg.Port))},
 							Ports: []apiv1.ContainerPort{
 								{
 									Name:          "ssh",
 									Protocol:      apiv1.ProtocolTCP,
-									ContainerPort: 2222,
+									ContainerPort: cfg.Port,
 								},
 							},
 							VolumeMounts: []apiv1.VolumeMount{
@@ -260,24 +799,56 @@ func getWorkerTemplate(image string) (map[string]interface{}, error) {
 		},
 	}
 
-	svc := &apiv1.Service{
+	secret, err := workerSecret(name)
+	if err != nil {
+		return nil, err
+	}
+
+	template["deployment"] = dt
+	template["service"] = workerService(name, cfg)
+	template["secret"] = secret
+
+	return template, nil
+}
+
+func workerService(name string, cfg config.Workers) *apiv1.Service {
+	return &apiv1.Service{
 		ObjectMeta: metav1.ObjectMeta{
-			Name: name,
+			Name:   name,
+			Labels: workerLabels(),
 		},
 		Spec: apiv1.ServiceSpec{
 			Ports: []apiv1.ServicePort{
 				{
 					Name: "ssh",
-					Port: 2222,
+					Port: cfg.Port,
 				},
 			},
-			Type: apiv1.ServiceTypeLoadBalancer,
+			Type: newExposure(cfg).serviceType(),
 			Selector: map[string]string{
 				"name": name,
 			},
 		},
 	}
+}
+
+func workerSecret(name string) (*apiv1.Secret, error) {
+	data, err := newSSHKey()
+	if err != nil {
+		return nil, err
+	}
+	return &apiv1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   name,
+			Labels: workerLabels(),
+		},
+		Type: apiv1.SecretTypeOpaque,
+		Data: data,
+	}, nil
+}
 
+// newSSHKey generates the secret data of a worker key pair
+func newSSHKey() (map[string][]byte, error) {
 	sshKey, err := rsa.GenerateKey(rand.Reader, 2048)
 	if err != nil {
 		return nil, err
@@ -290,23 +861,10 @@ func getWorkerTemplate(image string) (map[string]interface{}, error) {
 	if err != nil {
 		return nil, err
 	}
-	data := map[string][]byte{
+	return map[string][]byte{
 		"id_rsa":     privateKeyByte,
 		"id_rsa.pub": []byte(publicKeyString),
-	}
-	secret := &apiv1.Secret{
-		ObjectMeta: metav1.ObjectMeta{
-			Name: name,
-		},
-		Type: apiv1.SecretTypeOpaque,
-		Data: data,
-	}
-
-	template["deployment"] = dt
-	template["service"] = svc
-	template["secret"] = secret
-
-	return template, nil
+	}, nil
 }
 
 func int32Ptr(i int32) *int32 { return &i }
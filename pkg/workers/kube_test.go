@@ -0,0 +1,604 @@
+package workers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ghodss/yaml"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+	appsv1 "k8s.io/api/apps/v1"
+	apiv1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	ktesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/mjudeikis/osa-labs/pkg/api"
+	"github.com/mjudeikis/osa-labs/pkg/config"
+	"github.com/mjudeikis/osa-labs/pkg/store"
+)
+
+const testNamespace = "workers-test"
+
+func testConfig() config.Workers {
+	cfg := config.Default().Workers
+	cfg.Number = 2
+	cfg.Max = 2
+	return cfg
+}
+
+// newTestKube returns a kube backend talking to a fake clientset
+func newTestKube(t *testing.T, cfg config.Workers, objects ...runtime.Object) (*kubeWorkers, *fake.Clientset) {
+	t.Helper()
+	log := logrus.NewEntry(logrus.StandardLogger())
+	storage, err := store.New(log, t.TempDir(), "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cli := fake.NewSimpleClientset(objects...)
+	return newKubeWorkers(log, storage, testNamespace, cfg, cli), cli
+}
+
+// markReady does what the cluster would: the deployment of the worker rolls
+// out and its service gets a load balancer IP
+func markReady(t *testing.T, cli kubernetes.Interface, name, ip string) {
+	t.Helper()
+	dc, err := cli.AppsV1().Deployments(testNamespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dc.Status = appsv1.DeploymentStatus{
+		ObservedGeneration: dc.GetGeneration(),
+		Replicas:           1,
+		UpdatedReplicas:    1,
+		ReadyReplicas:      1,
+	}
+	_, err = cli.AppsV1().Deployments(testNamespace).UpdateStatus(dc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	svc, err := cli.CoreV1().Services(testNamespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc.Spec.ClusterIP = "172.30.0.1"
+	svc.Status.LoadBalancer.Ingress = []apiv1.LoadBalancerIngress{{IP: ip}}
+	_, err = cli.CoreV1().Services(testNamespace).UpdateStatus(svc)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// simulateCluster marks every new worker ready until the context is done
+func simulateCluster(ctx context.Context, t *testing.T, cli kubernetes.Interface) {
+	seen := map[string]bool{}
+	wait.Until(func() {
+		deploymentList, err := cli.AppsV1().Deployments(testNamespace).List(metav1.ListOptions{})
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		for _, dc := range deploymentList.Items {
+			if seen[dc.GetName()] {
+				continue
+			}
+			seen[dc.GetName()] = true
+			markReady(t, cli, dc.GetName(), fmt.Sprintf("10.0.0.%d", len(seen)))
+		}
+	}, 10*time.Millisecond, ctx.Done())
+}
+
+// createWorkers creates n workers and returns their names
+func createWorkers(t *testing.T, k *kubeWorkers, n int) []string {
+	t.Helper()
+	var names []string
+	for i := 0; i < n; i++ {
+		name, err := k.createWorker()
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+func TestCreate(t *testing.T) {
+	cfg := testConfig()
+	k, cli := newTestKube(t, cfg)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	go k.Run(ctx)
+	go simulateCluster(ctx, t, cli)
+
+	err := k.Create(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deploymentList, err := cli.AppsV1().Deployments(testNamespace).List(metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deploymentList.Items) != cfg.Number {
+		t.Fatalf("expected %d deployments, got %d", cfg.Number, len(deploymentList.Items))
+	}
+	for _, dc := range deploymentList.Items {
+		if !isWorker(dc.ObjectMeta) || dc.GetLabels()[labelReserved] != "false" {
+			t.Errorf("deployment %s has labels %v", dc.GetName(), dc.GetLabels())
+		}
+		if _, err := cli.CoreV1().Services(testNamespace).Get(dc.GetName(), metav1.GetOptions{}); err != nil {
+			t.Errorf("service of %s: %v", dc.GetName(), err)
+		}
+		if _, err := cli.CoreV1().Secrets(testNamespace).Get(dc.GetName(), metav1.GetOptions{}); err != nil {
+			t.Errorf("secret of %s: %v", dc.GetName(), err)
+		}
+	}
+
+	workers, err := k.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(workers) != cfg.Number {
+		t.Fatalf("expected %d workers, got %d", cfg.Number, len(workers))
+	}
+	for _, wk := range workers {
+		if wk.Host == "" || wk.Port != cfg.Port || wk.User != workerUser || wk.SSHKey == "" || wk.Address == "" {
+			t.Errorf("incomplete worker %#v", wk)
+		}
+	}
+
+	data, err := k.store.Get("workers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var snapshot api.WorkersStore
+	err = yaml.Unmarshal(data, &snapshot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshot.Workers) != cfg.Number {
+		t.Errorf("expected %d workers in the snapshot, got %d", cfg.Number, len(snapshot.Workers))
+	}
+
+	// provisioning again creates nothing
+	err = k.Create(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deploymentList, err = cli.AppsV1().Deployments(testNamespace).List(metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deploymentList.Items) != cfg.Number {
+		t.Errorf("expected %d deployments after a second Create, got %d", cfg.Number, len(deploymentList.Items))
+	}
+}
+
+func TestCreateWorker(t *testing.T) {
+	cfg := testConfig()
+	cfg.Exposure.Type = config.ExposureNodePort
+	cfg.Port = 2022
+	k, cli := newTestKube(t, cfg)
+
+	name, err := k.createWorker()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dc, err := cli.AppsV1().Deployments(testNamespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dc.Spec.Strategy.Type != appsv1.RecreateDeploymentStrategyType {
+		t.Errorf("expected Recreate strategy, got %q", dc.Spec.Strategy.Type)
+	}
+	container := dc.Spec.Template.Spec.Containers[0]
+	if container.Image != cfg.Image || container.Ports[0].ContainerPort != cfg.Port {
+		t.Errorf("unexpected container %#v", container)
+	}
+	if !reflect.DeepEqual(container.Args, []string{"-port", "2022"}) {
+		t.Errorf("expected the worker to listen on the configured port, got args %q", container.Args)
+	}
+	if dc.Spec.Template.Spec.Volumes[0].Secret.SecretName != name {
+		t.Errorf("worker does not mount its secret")
+	}
+
+	svc, err := cli.CoreV1().Services(testNamespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if svc.Spec.Type != apiv1.ServiceTypeNodePort {
+		t.Errorf("expected NodePort service, got %q", svc.Spec.Type)
+	}
+
+	secret, err := cli.CoreV1().Secrets(testNamespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.ParsePrivateKey(secret.Data["id_rsa"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	public, _, _, _, err := ssh.ParseAuthorizedKey(secret.Data["id_rsa.pub"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(public.Marshal()) != string(signer.PublicKey().Marshal()) {
+		t.Error("public key does not match the private key")
+	}
+}
+
+func TestCreateWorkerRollback(t *testing.T) {
+	k, cli := newTestKube(t, testConfig())
+	cli.PrependReactor("create", "services", func(action ktesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.New("quota exceeded")
+	})
+
+	_, err := k.createWorker()
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+
+	deploymentList, err := cli.AppsV1().Deployments(testNamespace).List(metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	secretList, err := cli.CoreV1().Secrets(testNamespace).List(metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deploymentList.Items) != 0 || len(secretList.Items) != 0 {
+		t.Errorf("incomplete worker left behind: %d deployments, %d secrets", len(deploymentList.Items), len(secretList.Items))
+	}
+}
+
+func TestSyncWorker(t *testing.T) {
+	k, cli := newTestKube(t, testConfig())
+	name := createWorkers(t, k, 1)[0]
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	k.factory.Start(ctx.Done())
+	if !cache.WaitForCacheSync(ctx.Done(), k.synced...) {
+		t.Fatal("caches did not sync")
+	}
+	k.pool.setSynced()
+
+	changed, err := k.syncWorker(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !changed {
+		t.Error("expected the new worker to change the pool")
+	}
+	if k.pool.available() != 0 {
+		t.Error("worker is available before it is ready")
+	}
+
+	markReady(t, cli, name, "10.0.0.1")
+	waitForCache(t, func() bool {
+		svc, err := k.svcLister.Get(name)
+		return err == nil && len(svc.Status.LoadBalancer.Ingress) > 0
+	})
+	waitForCache(t, func() bool {
+		dc, err := k.dLister.Get(name)
+		return err == nil && deploymentReady(dc)
+	})
+
+	changed, err = k.syncWorker(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !changed || k.pool.available() != 1 {
+		t.Errorf("expected the ready worker to be available, changed %v", changed)
+	}
+	entries := k.pool.entries()
+	if entries[0].worker.Host != "10.0.0.1" || entries[0].worker.SSHKey == "" {
+		t.Errorf("unexpected worker %#v", entries[0].worker)
+	}
+
+	changed, err = k.syncWorker(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed {
+		t.Error("expected no change without updates")
+	}
+
+	_, err = k.Delete(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForCache(t, func() bool {
+		_, err := k.dLister.Get(name)
+		return kerrors.IsNotFound(err)
+	})
+	changed, err = k.syncWorker(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !changed || len(k.pool.entries()) != 0 {
+		t.Error("expected the deleted worker to leave the pool")
+	}
+}
+
+// waitForCache waits until the informer caches satisfy condition
+func waitForCache(t *testing.T, condition func() bool) {
+	t.Helper()
+	err := wait.PollImmediate(10*time.Millisecond, 5*time.Second, func() (bool, error) {
+		return condition(), nil
+	})
+	if err != nil {
+		t.Fatal("informer caches did not catch up")
+	}
+}
+
+func TestGet(t *testing.T) {
+	k, cli := newTestKube(t, testConfig())
+	names := createWorkers(t, k, 3)
+	markReady(t, cli, names[0], "10.0.0.1")
+	markReady(t, cli, names[1], "10.0.0.2")
+
+	lease := &api.Lease{Claim: "c1"}
+	first, err := k.Get(lease, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first == nil || first.Lease != lease || !first.Reserved || first.Host == "" || first.SSHKey == "" {
+		t.Fatalf("expected a newly reserved worker, got %#v", first)
+	}
+	dc, err := cli.AppsV1().Deployments(testNamespace).Get(first.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dc.GetLabels()[labelReserved] != "true" || dc.GetAnnotations()[annotationLease] == "" {
+		t.Error("reservation is not kept on the deployment")
+	}
+
+	again, err := k.Get(&api.Lease{Claim: "c1"}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again == nil || again.Name != first.Name || again.Lease == lease {
+		t.Errorf("expected the same worker for the same claim, got %#v", again)
+	}
+
+	// the only free ready worker is left to the participant queued in front
+	wk, err := k.Get(&api.Lease{Claim: "c2"}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wk != nil {
+		t.Errorf("expected no worker with one participant ahead, got %s", wk.Name)
+	}
+	second, err := k.Get(&api.Lease{Claim: "c2"}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second == nil || second.Name == first.Name {
+		t.Fatalf("expected another worker, got %#v", second)
+	}
+
+	// the third worker is not ready
+	wk, err = k.Get(&api.Lease{Claim: "c3"}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wk != nil {
+		t.Errorf("expected the pool to be exhausted, got %s", wk.Name)
+	}
+}
+
+func TestGetFromInventory(t *testing.T) {
+	k, cli := newTestKube(t, testConfig())
+	names := createWorkers(t, k, 2)
+	markReady(t, cli, names[0], "10.0.0.1")
+	markReady(t, cli, names[1], "10.0.0.2")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go k.Run(ctx)
+	waitForCache(t, func() bool { return k.pool.available() == 2 })
+
+	cli.ClearActions()
+	first, err := k.Get(&api.Lease{Claim: "c1"}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first == nil || first.Host == "" || first.SSHKey == "" {
+		t.Fatalf("expected a newly reserved worker, got %#v", first)
+	}
+	again, err := k.Get(&api.Lease{Claim: "c1"}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again == nil || again.Name != first.Name {
+		t.Errorf("expected the same worker for the same claim, got %#v", again)
+	}
+	second, err := k.Get(&api.Lease{Claim: "c2"}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second == nil || second.Name == first.Name {
+		t.Errorf("expected another worker, got %#v", second)
+	}
+
+	// only the reservations are written, nothing is read from the API
+	for _, action := range cli.Actions() {
+		if action.GetVerb() != "update" || action.GetResource().Resource != "deployments" {
+			t.Errorf("unexpected API call %s %s", action.GetVerb(), action.GetResource().Resource)
+		}
+	}
+}
+
+func TestReleaseRecycles(t *testing.T) {
+	k, cli := newTestKube(t, testConfig())
+	name := createWorkers(t, k, 1)[0]
+	markReady(t, cli, name, "10.0.0.1")
+
+	wk, err := k.Get(&api.Lease{Claim: "c1"}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	released, err := k.Update(name, func(wk *api.Worker) {
+		wk.Reserved = false
+		wk.Lease = nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if released == nil || released.Reserved || released.Lease != nil {
+		t.Fatalf("expected a released worker, got %#v", released)
+	}
+	if released.SSHKey == wk.SSHKey {
+		t.Error("expected the key to be rotated")
+	}
+
+	dc, err := cli.AppsV1().Deployments(testNamespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dc.Spec.Template.GetAnnotations()[annotationRecycledAt] == "" {
+		t.Error("expected the pod to be replaced")
+	}
+	if dc.GetAnnotations()[annotationIdleSince] == "" {
+		t.Error("expected the release to be recorded")
+	}
+
+	// metadata changes do not recycle
+	_, err = k.Update(name, func(wk *api.Worker) {
+		wk.Metadata = "table-4"
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret, err := cli.CoreV1().Secrets(testNamespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(secret.Data["id_rsa"]) != released.SSHKey {
+		t.Error("expected the key to stay the same")
+	}
+}
+
+func TestUpdateAndDeleteMissing(t *testing.T) {
+	k, _ := newTestKube(t, testConfig())
+
+	wk, err := k.Update("missing", func(*api.Worker) {})
+	if err != nil || wk != nil {
+		t.Errorf("expected nil for a missing worker, got %v, %v", wk, err)
+	}
+	found, err := k.Delete("missing")
+	if err != nil || found {
+		t.Errorf("expected false for a missing worker, got %v, %v", found, err)
+	}
+	found, err = k.Recycle("missing")
+	if err != nil || found {
+		t.Errorf("expected false for a missing worker, got %v, %v", found, err)
+	}
+}
+
+func TestDelete(t *testing.T) {
+	k, cli := newTestKube(t, testConfig())
+	name := createWorkers(t, k, 1)[0]
+
+	found, err := k.Delete(name)
+	if err != nil || !found {
+		t.Fatalf("expected the worker to be deleted, got %v, %v", found, err)
+	}
+	if _, err := cli.CoreV1().Services(testNamespace).Get(name, metav1.GetOptions{}); !kerrors.IsNotFound(err) {
+		t.Errorf("expected the service to be deleted, got %v", err)
+	}
+	if _, err := cli.CoreV1().Secrets(testNamespace).Get(name, metav1.GetOptions{}); !kerrors.IsNotFound(err) {
+		t.Errorf("expected the secret to be deleted, got %v", err)
+	}
+}
+
+func TestScale(t *testing.T) {
+	cfg := testConfig()
+	cfg.Number = 1
+	cfg.Spares = 1
+	cfg.Max = 3
+	cfg.ScaleDownDelay = config.Duration{}
+	k, cli := newTestKube(t, cfg)
+	name := createWorkers(t, k, 1)[0]
+	markReady(t, cli, name, "10.0.0.1")
+
+	count := func() int {
+		deploymentList, err := cli.AppsV1().Deployments(testNamespace).List(metav1.ListOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(deploymentList.Items)
+	}
+
+	err := k.Scale()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count() != 1 {
+		t.Errorf("expected the spare to be kept, got %d workers", count())
+	}
+
+	_, err = k.Get(&api.Lease{Claim: "c1"}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = k.Scale()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count() != 2 {
+		t.Errorf("expected a spare next to the reserved worker, got %d workers", count())
+	}
+
+	_, err = k.Update(name, func(wk *api.Worker) {
+		wk.Reserved = false
+		wk.Lease = nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = k.Scale()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count() != 1 {
+		t.Errorf("expected the idle worker to be removed, got %d workers", count())
+	}
+}
+
+func TestDesiredWorkers(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		number   int
+		spares   int
+		max      int
+		reserved int
+		want     int
+	}{
+		{name: "no spares", number: 5, max: 5, reserved: 3, want: 5},
+		{name: "spares within number", number: 5, spares: 2, max: 10, reserved: 3, want: 5},
+		{name: "spares above number", number: 5, spares: 2, max: 10, reserved: 4, want: 6},
+		{name: "capped", number: 5, spares: 2, max: 10, reserved: 10, want: 10},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.Workers{Number: tt.number, Spares: tt.spares, Max: tt.max}
+			if got := desiredWorkers(cfg, tt.reserved); got != tt.want {
+				t.Errorf("expected %d, got %d", tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,449 @@
+package workers
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/mjudeikis/osa-labs/pkg/api"
+	"github.com/mjudeikis/osa-labs/pkg/config"
+	"github.com/mjudeikis/osa-labs/pkg/metrics"
+	"github.com/mjudeikis/osa-labs/pkg/store"
+	"github.com/mjudeikis/osa-labs/pkg/utils/random"
+	"github.com/mjudeikis/osa-labs/pkg/utils/wait"
+)
+
+const (
+	// localDialTimeout bounds the readiness check of a local worker
+	localDialTimeout = 100 * time.Millisecond
+	// localPollInterval is how often Create checks the workers are ready
+	localPollInterval = time.Second
+)
+
+func init() {
+	Register(config.BackendLocal, newLocal)
+}
+
+// localWorkers runs every worker as a cmd/ssh process listening on an
+// ephemeral port of this host, e.g. in dev mode on a laptop. The workers and
+// their reservations go away with the frontend.
+type localWorkers struct {
+	sync.Mutex
+	log       *logrus.Entry
+	namespace string
+	config    config.Workers
+	store     store.Store
+	// dir holds a directory with the key pair of every worker
+	dir       string
+	inventory inventory
+	processes map[string]*localProcess
+}
+
+var _ Workers = &localWorkers{}
+
+// localProcess is the cmd/ssh process of a worker
+type localProcess struct {
+	cmd *exec.Cmd
+	dir string
+	// exited is closed once the process is gone
+	exited chan struct{}
+}
+
+// newLocal returns a worker manager running workers as processes of
+// cfg.Local.Binary
+func newLocal(log *logrus.Entry, storage store.Store, namespace string, cfg config.Workers) (Workers, error) {
+	return &localWorkers{
+		log:       log,
+		namespace: namespace,
+		config:    cfg,
+		store:     storage,
+		dir:       filepath.Join(os.TempDir(), "osa-labs", namespace),
+		processes: map[string]*localProcess{},
+	}, nil
+}
+
+func (l *localWorkers) Get(lease *api.Lease, ahead int) (*api.Worker, error) {
+	l.Lock()
+	defer l.Unlock()
+
+	wk := l.inventory.get(lease, ahead, l.ready)
+	if wk != nil && wk.Lease == lease {
+		l.inventory.snapshot(l.log, l.store)
+	}
+	return wk, nil
+}
+
+func (l *localWorkers) List() ([]api.Worker, error) {
+	l.Lock()
+	defer l.Unlock()
+
+	return l.inventory.list(), nil
+}
+
+func (l *localWorkers) Update(name string, fn func(*api.Worker)) (*api.Worker, error) {
+	l.Lock()
+	defer l.Unlock()
+
+	found, released := l.inventory.update(name, fn)
+	if !found {
+		return nil, nil
+	}
+	// released workers are recycled before the next participant gets them
+	if released {
+		l.log.Infof("recycling released worker %s", name)
+		err := l.recycle(name)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	l.inventory.snapshot(l.log, l.store)
+	wk := l.inventory.find(name).worker()
+	return &wk, nil
+}
+
+func (l *localWorkers) Delete(name string) (bool, error) {
+	l.Lock()
+	defer l.Unlock()
+
+	if l.inventory.find(name) == nil {
+		return false, nil
+	}
+	l.stopWorker(name)
+	l.inventory.remove(name)
+	l.inventory.snapshot(l.log, l.store)
+	return true, nil
+}
+
+// Recycle restarts the process of the named worker with a new key pair and
+// port. It returns false if there is no such worker.
+func (l *localWorkers) Recycle(name string) (bool, error) {
+	l.Lock()
+	defer l.Unlock()
+
+	if l.inventory.find(name) == nil {
+		return false, nil
+	}
+	l.log.Infof("recycling worker %s", name)
+	err := l.recycle(name)
+	if err != nil {
+		return true, err
+	}
+	l.inventory.snapshot(l.log, l.store)
+	return true, nil
+}
+
+func (l *localWorkers) recycle(name string) error {
+	err := l.restartWorker(name)
+	if err != nil {
+		return err
+	}
+	metrics.WorkersRecycled.WithLabelValues(l.namespace).Inc()
+	return nil
+}
+
+// restartWorker replaces the process of a worker
+func (l *localWorkers) restartWorker(name string) error {
+	l.stopWorker(name)
+	wk, err := l.startWorker(name)
+	if err != nil {
+		return err
+	}
+	e := l.inventory.find(name)
+	e.Host, e.Port, e.IP, e.Address, e.SSHKey = wk.Host, wk.Port, wk.IP, wk.Address, wk.SSHKey
+	return nil
+}
+
+// GC restarts workers whose process exited and removes the key pairs left
+// behind by earlier runs
+func (l *localWorkers) GC() error {
+	l.Lock()
+	defer l.Unlock()
+
+	for _, e := range l.inventory.entries {
+		p := l.processes[e.Name]
+		if p != nil && !exited(p) {
+			continue
+		}
+		l.log.Infof("restarting exited worker %s", e.Name)
+		err := l.restartWorker(e.Name)
+		if err != nil {
+			return err
+		}
+		metrics.WorkerGC.WithLabelValues(l.namespace, "repaired").Inc()
+	}
+
+	dirs, err := ioutil.ReadDir(l.dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, dir := range dirs {
+		if _, ok := l.processes[dir.Name()]; ok {
+			continue
+		}
+		l.log.Infof("removing leftover worker directory %s", dir.Name())
+		err = os.RemoveAll(filepath.Join(l.dir, dir.Name()))
+		if err != nil {
+			return err
+		}
+		metrics.WorkerGC.WithLabelValues(l.namespace, "removed").Inc()
+	}
+	return nil
+}
+
+func (l *localWorkers) Teardown() error {
+	l.Lock()
+	defer l.Unlock()
+
+	for _, e := range l.inventory.entries {
+		l.stopWorker(e.Name)
+	}
+	l.inventory.entries = nil
+	l.inventory.snapshot(l.log, l.store)
+	return os.RemoveAll(l.dir)
+}
+
+func (l *localWorkers) Create(ctx context.Context) error {
+	err := l.GC()
+	if err != nil {
+		return err
+	}
+
+	l.Lock()
+	n := l.config.Number - len(l.inventory.entries)
+	l.log.Infof("create workers %v", n)
+	for i := 0; i < n; i++ {
+		_, err := l.createWorker()
+		if err != nil {
+			l.Unlock()
+			return err
+		}
+	}
+	l.Unlock()
+
+	err = wait.PollImmediateUntil(localPollInterval, func() (bool, error) {
+		l.Lock()
+		defer l.Unlock()
+		ready := 0
+		for _, e := range l.inventory.entries {
+			// provisioning is retried, which restarts the worker
+			if p := l.processes[e.Name]; p == nil || exited(p) {
+				return false, fmt.Errorf("worker %s exited", e.Name)
+			}
+			if l.ready(e) {
+				ready++
+			}
+		}
+		l.log.Debugf("%d of %d local workers ready", ready, l.config.Number)
+		return ready >= l.config.Number, nil
+	}, ctx.Done())
+	if err != nil {
+		return err
+	}
+
+	l.Lock()
+	defer l.Unlock()
+	l.inventory.snapshot(l.log, l.store)
+	return nil
+}
+
+// Run stops the worker processes once the context is done, so they do not
+// outlive the frontend
+func (l *localWorkers) Run(ctx context.Context) {
+	<-ctx.Done()
+	err := l.Teardown()
+	if err != nil {
+		l.log.Warnf("stopping local workers: %v", err)
+	}
+}
+
+// Scale keeps config.Spares free workers on top of the reserved ones, within
+// config.Number and config.Max workers, like the kube backend
+func (l *localWorkers) Scale() error {
+	l.Lock()
+	defer l.Unlock()
+
+	reserved := l.inventory.reserved()
+	desired := desiredWorkers(l.config, reserved)
+	metrics.WorkersDesired.WithLabelValues(l.namespace).Set(float64(desired))
+
+	current := len(l.inventory.entries)
+	idle := l.inventory.idle(time.Now().Add(-l.config.ScaleDownDelay.Duration))
+	switch {
+	case current < desired:
+		l.log.Infof("scaling workers up from %d to %d, %d reserved", current, desired, reserved)
+		for i := current; i < desired; i++ {
+			_, err := l.createWorker()
+			if err != nil {
+				return err
+			}
+		}
+
+	case current > desired && len(idle) > 0:
+		n := current - desired
+		if n > len(idle) {
+			n = len(idle)
+		}
+		l.log.Infof("scaling workers down from %d to %d, %d reserved", current, current-n, reserved)
+		for _, e := range idle[:n] {
+			l.stopWorker(e.Name)
+			l.inventory.remove(e.Name)
+			l.log.Infof("removed idle worker %s", e.Name)
+		}
+
+	default:
+		return nil
+	}
+
+	l.inventory.snapshot(l.log, l.store)
+	return nil
+}
+
+func (l *localWorkers) createWorker() (string, error) {
+	name, err := random.LowerCaseAlphaString(10)
+	if err != nil {
+		return "", err
+	}
+	wk, err := l.startWorker(name)
+	if err != nil {
+		return "", err
+	}
+	l.inventory.add(*wk)
+	l.log.Infof("created worker %s on port %d", name, wk.Port)
+	return name, nil
+}
+
+// startWorker generates a key pair for the worker and starts its process
+func (l *localWorkers) startWorker(name string) (*api.Worker, error) {
+	dir := filepath.Join(l.dir, name)
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, err
+	}
+	data, err := newSSHKey()
+	if err != nil {
+		return nil, err
+	}
+	for file, b := range data {
+		err = ioutil.WriteFile(filepath.Join(dir, file), b, 0600)
+		if err != nil {
+			return nil, err
+		}
+	}
+	port, err := freePort(l.config.Local.Host)
+	if err != nil {
+		return nil, err
+	}
+
+	// the worker key doubles as host key, like in the worker image. The
+	// worker only listens where participants reach it, and does not get the
+	// environment of the frontend, which may enable password logins.
+	cmd := exec.Command(l.config.Local.Binary,
+		"-address", l.config.Local.Host,
+		"-port", strconv.Itoa(port),
+		"-public-key", filepath.Join(dir, "id_rsa.pub"),
+		"-host-key", filepath.Join(dir, "id_rsa"),
+	)
+	cmd.Env = localEnv()
+	output := l.log.WithField("worker", name).WriterLevel(logrus.DebugLevel)
+	cmd.Stdout = output
+	cmd.Stderr = output
+	err = cmd.Start()
+	if err != nil {
+		output.Close()
+		os.RemoveAll(dir)
+		return nil, err
+	}
+
+	p := &localProcess{cmd: cmd, dir: dir, exited: make(chan struct{})}
+	go func() {
+		cmd.Wait()
+		output.Close()
+		close(p.exited)
+	}()
+	l.processes[name] = p
+
+	return &api.Worker{
+		Name:    name,
+		Host:    l.config.Local.Host,
+		Port:    int32(port),
+		IP:      l.config.Local.Host,
+		User:    workerUser,
+		Address: net.JoinHostPort(l.config.Local.Host, strconv.Itoa(port)),
+		SSHKey:  string(data["id_rsa"]),
+	}, nil
+}
+
+// stopWorker kills the process of a worker and removes its key pair
+func (l *localWorkers) stopWorker(name string) {
+	p := l.processes[name]
+	if p == nil {
+		return
+	}
+	if !exited(p) {
+		p.cmd.Process.Kill()
+		<-p.exited
+	}
+	if err := os.RemoveAll(p.dir); err != nil {
+		l.log.Warnf("removing worker directory %s: %v", p.dir, err)
+	}
+	delete(l.processes, name)
+}
+
+// ready returns true once the worker process accepts connections
+func (l *localWorkers) ready(e *entry) bool {
+	p := l.processes[e.Name]
+	if p == nil || exited(p) {
+		return false
+	}
+	conn, err := net.DialTimeout("tcp", e.Address, localDialTimeout)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+func exited(p *localProcess) bool {
+	select {
+	case <-p.exited:
+		return true
+	default:
+		return false
+	}
+}
+
+// localEnv is the environment of the worker processes and the shells they
+// start
+func localEnv() []string {
+	var env []string
+	for _, name := range []string{"PATH", "HOME", "USER", "LOGNAME", "SHELL", "LANG"} {
+		if value, ok := os.LookupEnv(name); ok {
+			env = append(env, name+"="+value)
+		}
+	}
+	return env
+}
+
+// freePort returns a port nothing listens on at host. Another process may
+// take it before the worker binds it, which only fails that worker.
+func freePort(host string) (int, error) {
+	listener, err := net.Listen("tcp", net.JoinHostPort(host, "0"))
+	if err != nil {
+		return 0, err
+	}
+	defer listener.Close()
+	return listener.Addr().(*net.TCPAddr).Port, nil
+}
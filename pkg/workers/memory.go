@@ -0,0 +1,123 @@
+package workers
+
+import (
+	"context"
+	"sync"
+
+	"github.com/mjudeikis/osa-labs/pkg/api"
+)
+
+// Memory keeps a fixed set of workers in memory, for tests of code using a
+// worker backend. Workers are ready unless marked otherwise and recycling only
+// counts how often a worker was recycled.
+type Memory struct {
+	sync.Mutex
+	inventory inventory
+	notReady  map[string]bool
+	recycled  map[string]int
+}
+
+var _ Workers = &Memory{}
+
+// NewMemory returns a backend holding the given workers
+func NewMemory(workers ...api.Worker) *Memory {
+	m := &Memory{
+		notReady: map[string]bool{},
+		recycled: map[string]int{},
+	}
+	for _, wk := range workers {
+		m.inventory.add(wk)
+	}
+	return m
+}
+
+// SetReady marks the named worker as ready to be handed out or not
+func (m *Memory) SetReady(name string, ready bool) {
+	m.Lock()
+	defer m.Unlock()
+
+	m.notReady[name] = !ready
+}
+
+// Recycled returns how often the named worker was recycled
+func (m *Memory) Recycled(name string) int {
+	m.Lock()
+	defer m.Unlock()
+
+	return m.recycled[name]
+}
+
+func (m *Memory) Get(lease *api.Lease, ahead int) (*api.Worker, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	return m.inventory.get(lease, ahead, func(e *entry) bool {
+		return !m.notReady[e.Name]
+	}), nil
+}
+
+func (m *Memory) List() ([]api.Worker, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	return m.inventory.list(), nil
+}
+
+func (m *Memory) Update(name string, fn func(*api.Worker)) (*api.Worker, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	found, released := m.inventory.update(name, fn)
+	if !found {
+		return nil, nil
+	}
+	if released {
+		m.recycled[name]++
+	}
+	wk := m.inventory.find(name).worker()
+	return &wk, nil
+}
+
+func (m *Memory) Delete(name string) (bool, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	if m.inventory.find(name) == nil {
+		return false, nil
+	}
+	m.inventory.remove(name)
+	return true, nil
+}
+
+func (m *Memory) Recycle(name string) (bool, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	if m.inventory.find(name) == nil {
+		return false, nil
+	}
+	m.recycled[name]++
+	return true, nil
+}
+
+func (m *Memory) GC() error {
+	return nil
+}
+
+func (m *Memory) Teardown() error {
+	m.Lock()
+	defer m.Unlock()
+
+	m.inventory.entries = nil
+	return nil
+}
+
+func (m *Memory) Create(ctx context.Context) error {
+	return nil
+}
+
+func (m *Memory) Run(ctx context.Context) {}
+
+func (m *Memory) Scale() error {
+	return nil
+}
@@ -0,0 +1,63 @@
+package workers
+
+import (
+	"testing"
+
+	"github.com/mjudeikis/osa-labs/pkg/api"
+)
+
+func TestMemory(t *testing.T) {
+	m := NewMemory(api.Worker{Name: "a"}, api.Worker{Name: "b"}, api.Worker{Name: "c"})
+	m.SetReady("c", false)
+
+	lease := &api.Lease{Claim: "c1"}
+	first, err := m.Get(lease, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first == nil || first.Name != "a" || first.Lease != lease {
+		t.Fatalf("expected worker a to be reserved, got %#v", first)
+	}
+	again, err := m.Get(&api.Lease{Claim: "c1"}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again == nil || again.Name != "a" || again.Lease == lease {
+		t.Errorf("expected the same worker for the same claim, got %#v", again)
+	}
+	if wk, _ := m.Get(&api.Lease{Claim: "c2"}, 1); wk != nil {
+		t.Errorf("expected no worker with one participant ahead, got %s", wk.Name)
+	}
+	if wk, _ := m.Get(&api.Lease{Claim: "c2"}, 0); wk == nil || wk.Name != "b" {
+		t.Errorf("expected worker b, got %#v", wk)
+	}
+	if wk, _ := m.Get(&api.Lease{Claim: "c3"}, 0); wk != nil {
+		t.Errorf("expected the not ready worker to be held back, got %s", wk.Name)
+	}
+
+	wk, err := m.Update("a", func(wk *api.Worker) {
+		wk.Reserved = false
+		wk.Lease = nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wk == nil || wk.Reserved || m.Recycled("a") != 1 {
+		t.Errorf("expected worker a to be released and recycled, got %#v", wk)
+	}
+	if wk, _ := m.Update("missing", func(*api.Worker) {}); wk != nil {
+		t.Error("expected nil for a missing worker")
+	}
+
+	found, err := m.Delete("b")
+	if err != nil || !found {
+		t.Fatalf("expected worker b to be deleted, got %v, %v", found, err)
+	}
+	workers, err := m.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(workers) != 2 || workers[0].Name != "a" || workers[1].Name != "c" {
+		t.Errorf("unexpected workers %v", workers)
+	}
+}
@@ -0,0 +1,155 @@
+package workers
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/mjudeikis/osa-labs/pkg/api"
+	"github.com/mjudeikis/osa-labs/pkg/config"
+	"github.com/mjudeikis/osa-labs/pkg/metrics"
+	"github.com/mjudeikis/osa-labs/pkg/store"
+)
+
+func init() {
+	Register(config.BackendStatic, newStatic)
+}
+
+// staticWorkers hands out pre-existing hosts listed in the configuration. The
+// hosts are not managed, so their reservations are kept in the store and they
+// are neither recycled nor scaled.
+type staticWorkers struct {
+	sync.Mutex
+	log       *logrus.Entry
+	namespace string
+	store     store.Store
+	inventory inventory
+}
+
+var _ Workers = &staticWorkers{}
+
+// newStatic returns a worker manager handing out the hosts of cfg.Static
+func newStatic(log *logrus.Entry, storage store.Store, namespace string, cfg config.Workers) (Workers, error) {
+	s := &staticWorkers{
+		log:       log,
+		namespace: namespace,
+		store:     storage,
+	}
+	for _, host := range cfg.Static {
+		key, err := ioutil.ReadFile(host.SSHKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		wk := api.Worker{
+			Name:    host.Name,
+			Host:    host.Host,
+			Port:    host.Port,
+			IP:      host.Host,
+			User:    host.User,
+			Address: host.Address,
+			SSHKey:  string(key),
+		}
+		if wk.User == "" {
+			wk.User = workerUser
+		}
+		if wk.Address == "" {
+			wk.Address = net.JoinHostPort(host.Host, strconv.Itoa(int(host.Port)))
+		}
+		s.inventory.add(wk)
+	}
+	return s, s.inventory.restore(storage)
+}
+
+func (s *staticWorkers) Get(lease *api.Lease, ahead int) (*api.Worker, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	wk := s.inventory.get(lease, ahead, func(*entry) bool { return true })
+	if wk == nil || wk.Lease != lease {
+		return wk, nil
+	}
+	err := s.inventory.save(s.store)
+	if err != nil {
+		// do not hand out a reservation which is not recorded
+		s.inventory.update(wk.Name, func(wk *api.Worker) {
+			wk.Reserved = false
+			wk.Lease = nil
+		})
+		return nil, err
+	}
+	return wk, nil
+}
+
+func (s *staticWorkers) List() ([]api.Worker, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.inventory.list(), nil
+}
+
+func (s *staticWorkers) Update(name string, fn func(*api.Worker)) (*api.Worker, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	found, _ := s.inventory.update(name, fn)
+	if !found {
+		return nil, nil
+	}
+	err := s.inventory.save(s.store)
+	if err != nil {
+		return nil, err
+	}
+	wk := s.inventory.find(name).worker()
+	return &wk, nil
+}
+
+// Delete is not supported, hosts are removed from the configuration
+func (s *staticWorkers) Delete(name string) (bool, error) {
+	return s.unsupported(name)
+}
+
+// Recycle is not supported, the hosts are not managed by the frontend
+func (s *staticWorkers) Recycle(name string) (bool, error) {
+	return s.unsupported(name)
+}
+
+func (s *staticWorkers) unsupported(name string) (bool, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.inventory.find(name) == nil {
+		return false, nil
+	}
+	return true, ErrUnsupported
+}
+
+func (s *staticWorkers) GC() error {
+	return nil
+}
+
+func (s *staticWorkers) Teardown() error {
+	return ErrUnsupported
+}
+
+// Create records the inventory in the store. The hosts exist already.
+func (s *staticWorkers) Create(ctx context.Context) error {
+	s.Lock()
+	defer s.Unlock()
+
+	s.log.Infof("serving %d static workers", len(s.inventory.entries))
+	return s.inventory.save(s.store)
+}
+
+func (s *staticWorkers) Run(ctx context.Context) {}
+
+func (s *staticWorkers) Scale() error {
+	s.Lock()
+	defer s.Unlock()
+
+	metrics.WorkersDesired.WithLabelValues(s.namespace).Set(float64(len(s.inventory.entries)))
+	return nil
+}
@@ -1,10 +1,49 @@
 package workers
 
 import (
+	"context"
+	"errors"
+
 	"github.com/mjudeikis/osa-labs/pkg/api"
 )
 
+// ErrUnsupported is returned for operations a backend cannot carry out, e.g.
+// removing a host of the static inventory
+var ErrUnsupported = errors.New("not supported by the worker backend")
+
+// Workers manages the worker pool of a lab. The backend is the source of
+// truth for which workers exist and who holds them.
 type Workers interface {
-	Get() (*api.Worker, error)
-	Create() error
+	// Get returns the worker already leased to lease.Claim, or reserves a
+	// free and ready worker for it, leaving ahead free workers for the
+	// participants queued in front. A newly reserved worker carries the
+	// given lease. It returns nil if no worker is available.
+	Get(lease *api.Lease, ahead int) (*api.Worker, error)
+	// List returns all workers with their reservation state
+	List() ([]api.Worker, error)
+	// Update applies fn to the reservation state and metadata of the named
+	// worker. A worker released by fn is recycled, which ends the session of
+	// its holder, so callers only release on behalf of the lease holder, an
+	// admin or the lease expiry. It returns nil if there is no such worker.
+	Update(name string, fn func(*api.Worker)) (*api.Worker, error)
+	// Delete removes the named worker. It returns false if there is no such
+	// worker.
+	Delete(name string) (bool, error)
+	// Recycle resets the named worker to a pristine state with a new SSH
+	// key. Workers are also recycled when they are released. It returns
+	// false if there is no such worker.
+	Recycle(name string) (bool, error)
+	// GC repairs incomplete workers and removes resources left behind by
+	// removed ones
+	GC() error
+	// Teardown removes all workers
+	Teardown() error
+	// Create provisions the configured number of workers and waits until
+	// they are ready
+	Create(ctx context.Context) error
+	// Run keeps the worker inventory in sync until the context is done
+	Run(ctx context.Context)
+	// Scale creates or removes free workers so the configured number of
+	// spares is kept on top of the reserved workers
+	Scale() error
 }